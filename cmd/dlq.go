@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/trunov/mediahub/internal/config"
+	"github.com/trunov/mediahub/internal/queue"
+	"github.com/trunov/mediahub/internal/redisholder"
+)
+
+const dlqUsage = `usage: mediahub dlq <command>
+
+commands:
+  list [limit]      list dead-lettered jobs, oldest first (default limit 50)
+  show <id>         print a dead-lettered job with its last error
+  replay <id>       put a job back into the work stream
+  replay-all        put every dead-lettered job back into the work stream
+  purge <id>        delete a dead-lettered job
+  purge-all         delete every dead-lettered job`
+
+func runDLQ(cfg *config.Config, args []string, logger *slog.Logger) error {
+	if len(args) == 0 {
+		return errors.New(dlqUsage)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	holder, err := redisholder.Build(ctx, cfg, logger)
+	if err != nil {
+		return err
+	}
+	dlq := queue.NewDeadLetters(holder.Get(), cfg.WebP)
+
+	arg := func() (string, error) {
+		if len(args) < 2 {
+			return "", fmt.Errorf("dlq %s: missing job id\n\n%s", args[0], dlqUsage)
+		}
+		return args[1], nil
+	}
+
+	switch args[0] {
+	case "list":
+		limit := int64(50)
+		if len(args) > 1 {
+			if limit, err = strconv.ParseInt(args[1], 10, 64); err != nil {
+				return fmt.Errorf("dlq list: invalid limit %q", args[1])
+			}
+		}
+		jobs, err := dlq.List(ctx, "", limit)
+		if err != nil {
+			return err
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tTYPE\tATTEMPTS\tFAILED AT\tLAST ERROR")
+		for _, j := range jobs {
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\n", j.ID, j.Type, j.Attempts, j.FailedAt.Format("2006-01-02 15:04:05"), j.LastError)
+		}
+		return tw.Flush()
+
+	case "show":
+		id, err := arg()
+		if err != nil {
+			return err
+		}
+		job, err := dlq.Get(ctx, id)
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(job)
+
+	case "replay":
+		id, err := arg()
+		if err != nil {
+			return err
+		}
+		if err := dlq.Replay(ctx, id); err != nil {
+			return err
+		}
+		fmt.Printf("replayed %s\n", id)
+
+	case "replay-all":
+		n, err := dlq.ReplayAll(ctx)
+		fmt.Printf("replayed %d jobs\n", n)
+		return err
+
+	case "purge":
+		id, err := arg()
+		if err != nil {
+			return err
+		}
+		if err := dlq.Purge(ctx, id); err != nil {
+			return err
+		}
+		fmt.Printf("purged %s\n", id)
+
+	case "purge-all":
+		n, err := dlq.PurgeAll(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("purged %d jobs\n", n)
+
+	default:
+		return fmt.Errorf("dlq: unknown command %q\n\n%s", args[0], dlqUsage)
+	}
+
+	return nil
+}
@@ -1,15 +1,30 @@
 package main
 
 import (
+	"errors"
+	"flag"
+	"fmt"
 	"log"
+	"log/slog"
+	"os"
 	"time"
 
 	"github.com/getsentry/sentry-go"
+	"github.com/trunov/mediahub/cmd/migrate"
 	"github.com/trunov/mediahub/internal/app"
 	"github.com/trunov/mediahub/internal/config"
+	"github.com/trunov/mediahub/internal/logging"
 )
 
-const file = "config.json"
+const usage = `usage: mediahub [--config file] <command>
+
+commands:
+  serve                     run the HTTP API
+  worker                    run the queue workers and the purge job
+  migrate up|down|status    apply pending migrations, roll back the last one or list them
+  dlq <command>             inspect, replay and purge dead-lettered jobs
+
+flags:`
 
 func initSentry(cfg *config.SentryConfig, version string) error {
 	return sentry.Init(sentry.ClientOptions{
@@ -20,26 +35,98 @@ func initSentry(cfg *config.SentryConfig, version string) error {
 }
 
 func main() {
+	defaultConfig, ok := os.LookupEnv(config.EnvPrefix + "CONFIG")
+	if !ok {
+		defaultConfig = "config.json"
+	}
+	configFile := flag.String("config", defaultConfig, "path to the json config file, empty to configure only through "+config.EnvPrefix+"* variables")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
 	cfg := config.NewConfig()
-	err := cfg.Read(file)
+	err := cfg.Read(*configFile)
 	if err != nil {
 		log.Fatal(err)
 	}
+	// migrate and dlq run without the settings of the services they don't start
+	validate := cfg.Validate
+	switch args[0] {
+	case "migrate":
+		validate = cfg.ValidateMigrate
+	case "dlq":
+		validate = cfg.ValidateDLQ
+	}
+	if err := validate(); err != nil {
+		log.Fatal(err)
+	}
 
-	err = initSentry(&cfg.Sentry, "v1")
+	logger, err := logging.New(cfg.Log, os.Stderr)
 	if err != nil {
-		log.Fatalf("sentry.Init: %s", err)
+		log.Fatal(err)
+	}
+	// also routes the standard log package and libraries using it through logger
+	slog.SetDefault(logger)
+
+	switch args[0] {
+	case "serve":
+		err = run(cfg, *configFile, app.RoleServer, logger)
+	case "worker":
+		err = run(cfg, *configFile, app.RoleWorker, logger)
+	case "migrate":
+		err = runMigrate(cfg, args[1:])
+	case "dlq":
+		err = runDLQ(cfg, args[1:], logger)
+	default:
+		fmt.Fprintf(flag.CommandLine.Output(), "unknown command %q\n\n", args[0])
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+}
+
+func run(cfg *config.Config, configFile string, role app.Role, logger *slog.Logger) error {
+	err := initSentry(&cfg.Sentry, "v1")
+	if err != nil {
+		return fmt.Errorf("sentry.Init: %w", err)
 	}
 
 	// Flush buffered events before the program terminates.
 	defer sentry.Flush(2 * time.Second)
 
-	app, err := app.New(cfg)
+	a, err := app.New(cfg, configFile, role, logger)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
-	if err := app.Run(); err != nil {
-		log.Fatal(err)
+	// Run returns once the graceful shutdown is done
+	return a.Run()
+}
+
+func runMigrate(cfg *config.Config, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: mediahub migrate up|down|status")
+	}
+
+	switch args[0] {
+	case "up":
+		return migrate.Migrate(cfg.Database.DSN, migrate.Migrations)
+	case "down":
+		return migrate.Rollback(cfg.Database.DSN, migrate.Migrations)
+	case "status":
+		return migrate.Status(cfg.Database.DSN, migrate.Migrations)
+	default:
+		return fmt.Errorf("unknown migrate command %q, want up, down or status", args[0])
 	}
 }
@@ -12,7 +12,28 @@ import (
 //go:embed migrations
 var Migrations embed.FS
 
+// Migrate applies all pending migrations
 func Migrate(dsn string, path fs.FS) error {
+	return run(dsn, path, func(db *sql.DB) error {
+		return goose.Up(db, "migrations")
+	})
+}
+
+// Rollback reverts the most recently applied migration
+func Rollback(dsn string, path fs.FS) error {
+	return run(dsn, path, func(db *sql.DB) error {
+		return goose.Down(db, "migrations")
+	})
+}
+
+// Status prints the state of every migration
+func Status(dsn string, path fs.FS) error {
+	return run(dsn, path, func(db *sql.DB) error {
+		return goose.Status(db, "migrations")
+	})
+}
+
+func run(dsn string, path fs.FS, fn func(db *sql.DB) error) error {
 	db, err := sql.Open("pgx", dsn)
 	if err != nil {
 		return err
@@ -24,5 +45,8 @@ func Migrate(dsn string, path fs.FS) error {
 	}
 
 	goose.SetBaseFS(path)
-	return goose.Up(db, "migrations")
+	if err := goose.SetDialect("postgres"); err != nil {
+		return err
+	}
+	return fn(db)
 }
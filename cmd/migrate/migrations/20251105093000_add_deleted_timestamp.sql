@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE images ADD COLUMN deleted_timestamp TIMESTAMPTZ DEFAULT NULL;
+
+CREATE INDEX idx_images_deleted ON images (deleted_timestamp) WHERE is_deleted;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_images_deleted;
+
+ALTER TABLE images DROP COLUMN IF EXISTS deleted_timestamp;
+-- +goose StatementEnd
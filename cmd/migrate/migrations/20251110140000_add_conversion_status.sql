@@ -0,0 +1,9 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE images ADD COLUMN conversion_status VARCHAR(16) NOT NULL DEFAULT 'pending';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE images DROP COLUMN IF EXISTS conversion_status;
+-- +goose StatementEnd
@@ -0,0 +1,12 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE images ADD COLUMN upload_status VARCHAR(16) NOT NULL DEFAULT 'pending';
+
+-- rows written before the column existed were uploaded fire-and-forget, assume they made it
+UPDATE images SET upload_status = 'done';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE images DROP COLUMN IF EXISTS upload_status;
+-- +goose StatementEnd
@@ -0,0 +1,20 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE image_variants (
+    id                SERIAL PRIMARY KEY,
+    image_id          INTEGER NOT NULL REFERENCES images (id) ON DELETE CASCADE,
+    name              VARCHAR(32) NOT NULL,
+    key               VARCHAR(255) NOT NULL,
+    width             SMALLINT NOT NULL,
+    height            SMALLINT NOT NULL,
+    size              INTEGER NOT NULL,
+    mime_type         VARCHAR(10) NOT NULL,
+    created_timestamp TIMESTAMPTZ NOT NULL DEFAULT now(),
+    UNIQUE (image_id, name)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS image_variants;
+-- +goose StatementEnd
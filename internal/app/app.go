@@ -2,70 +2,272 @@ package app
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
-	"github.com/trunov/mediahub/cmd/migrate"
 	"github.com/trunov/mediahub/internal/cache"
 	"github.com/trunov/mediahub/internal/config"
+	"github.com/trunov/mediahub/internal/metrics"
+	"github.com/trunov/mediahub/internal/objectstore"
+	"github.com/trunov/mediahub/internal/purge"
 	"github.com/trunov/mediahub/internal/queue"
 	"github.com/trunov/mediahub/internal/r2"
 	"github.com/trunov/mediahub/internal/redisholder"
 	"github.com/trunov/mediahub/internal/redismanager"
 	"github.com/trunov/mediahub/internal/repository/storage"
+	"github.com/trunov/mediahub/internal/signer"
+	"github.com/trunov/mediahub/internal/tracing"
 	"github.com/trunov/mediahub/internal/transport/handler"
 	"github.com/trunov/mediahub/internal/transport/router"
 	use_case "github.com/trunov/mediahub/internal/use-case"
 )
 
+const (
+	defaultShutdownTimeout   = 30 * time.Second
+	defaultReadHeaderTimeout = 10 * time.Second
+	defaultIdleTimeout       = 2 * time.Minute
+)
+
+// Role selects what a process runs, so the API and the workers scale independently
+type Role string
+
+const (
+	RoleServer Role = "serve"  // HTTP API, conversion jobs are only enqueued
+	RoleWorker Role = "worker" // queue workers and the purge job
+)
+
 type App struct {
-	HttpServer *http.Server
+	HttpServer *http.Server // nil for a worker without metrics.port
+
+	cfg    *config.Config
+	log    *slog.Logger
+	worker *queue.Worker // nil for a server
+
+	// stopWorkers stops the background jobs, stopRedis the redis health loop (which closes the client)
+	stopWorkers context.CancelFunc
+	stopRedis   context.CancelFunc
+	background  sync.WaitGroup
+	closers     []func()
 }
 
-func New(cfg *config.Config) (*App, error) {
-	ctx := context.Background()
+// New builds the app for role. configFile is watched for settings that can change without a restart.
+func New(cfg *config.Config, configFile string, role Role, logger *slog.Logger) (*App, error) {
+	if role != RoleServer && role != RoleWorker {
+		return nil, fmt.Errorf("unknown role %q", role)
+	}
+	a := &App{cfg: cfg, log: logger.With("role", string(role))}
 
-	err := migrate.Migrate(cfg.Database.DSN, migrate.Migrations)
+	shutdownTracing, err := tracing.Init(context.Background(), cfg.Tracing, string(role))
 	if err != nil {
 		return nil, err
 	}
+	a.closers = append(a.closers, func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			a.log.Error("tracing shutdown failed", "error", err)
+		}
+	})
+
+	// redis outlives the workers during shutdown, they still ack and enqueue
+	redisCtx, stopRedis := context.WithCancel(context.Background())
+	a.stopRedis = stopRedis
+	ctx, stopWorkers := context.WithCancel(context.Background())
+	a.stopWorkers = stopWorkers
 
 	repo, err := storage.New(ctx, cfg.Database.DSN)
 	if err != nil {
 		return nil, err
 	}
+	a.closers = append(a.closers, repo.Close)
 
-	holder, err := redisholder.Build(ctx, cfg)
+	holder, err := redisholder.Build(redisCtx, cfg, a.log)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
 	rc := holder.Get()
-	rm := redismanager.NewManager(rc)
 
 	redisCache := cache.NewCache("mediahub:images", rc)
 
-	r2Storage := r2.NewStorage(&cfg.R2, redisCache)
+	objects, err := newObjectStore(cfg, redisCache, a.log)
+	if err != nil {
+		return nil, err
+	}
+	reloader := config.NewReloader(configFile, cfg, a.log)
+	a.background.Add(1)
+	go func() {
+		defer a.background.Done()
+		reloader.Run(ctx)
+	}()
+	if s3, ok := objects.(*r2.S3); ok {
+		reloader.Subscribe(func(next *config.Config) {
+			s3.SetRetries(next.R2.Retries(), next.R2.RetryBaseDelay.Duration())
+		})
+	}
+
+	metrics.RegisterStream(cfg.WebP.Stream, cfg.WebP.Group, func(ctx context.Context) (metrics.StreamStats, error) {
+		return queue.Stats(ctx, rc, cfg.WebP)
+	}, a.log)
+
+	if role == RoleWorker {
+		_, a.worker = queue.Init(ctx, rc, cfg.WebP, objects, repo, a.log)
+		reloader.Subscribe(func(next *config.Config) {
+			a.worker.Scale(next.WebP.Workers)
+		})
 
-	webpProducer := queue.Init(ctx, rc, cfg.WebP, r2Storage)
+		if cfg.Metrics.Port > 0 {
+			mux := http.NewServeMux()
+			mux.Handle("/metrics", metrics.Handler())
+			a.HttpServer = &http.Server{
+				Handler:           mux,
+				Addr:              fmt.Sprintf(":%d", cfg.Metrics.Port),
+				ReadHeaderTimeout: defaultReadHeaderTimeout,
+			}
+		}
+
+		a.background.Add(1)
+		go func() {
+			defer a.background.Done()
+			purge.New(repo, objects, cfg.Purge, a.log).Run(ctx)
+		}()
+
+		return a, nil
+	}
+
+	rm := redismanager.NewManager(rc)
+	webpProducer := queue.NewProducer(rc, cfg.WebP.Stream, cfg.WebP.MaxLen)
+
+	urlSigner, err := signer.New(cfg.Signing)
+	if err != nil {
+		return nil, err
+	}
 
-	uc := use_case.New(repo, rm, r2Storage, webpProducer)
+	uc := use_case.New(repo, rm, objects, redisCache, webpProducer, urlSigner, cfg, a.log)
 
-	h := handler.New(uc, cfg)
-	r := router.NewRouter(h)
+	h := handler.New(uc, cfg, urlSigner, a.log)
+	reloader.Subscribe(func(next *config.Config) {
+		h.SetUploadConfig(next.Upload)
+	})
+	admin := handler.NewAdmin(queue.NewDeadLetters(rc, cfg.WebP), cfg)
+	r := router.NewRouter(h, admin)
 
-	s := &http.Server{
-		Handler: r,
-		Addr:    fmt.Sprintf(":%d", cfg.Server.Port),
+	a.HttpServer = &http.Server{
+		Handler:           r,
+		Addr:              fmt.Sprintf(":%d", cfg.Server.Port),
+		ReadTimeout:       cfg.Server.ReadTimeout.Duration(),
+		ReadHeaderTimeout: orDefault(cfg.Server.ReadHeaderTimeout.Duration(), defaultReadHeaderTimeout),
+		WriteTimeout:      cfg.Server.WriteTimeout.Duration(),
+		IdleTimeout:       orDefault(cfg.Server.IdleTimeout.Duration(), defaultIdleTimeout),
 	}
 
-	return &App{
-		HttpServer: s,
-	}, nil
+	return a, nil
 }
 
+func newObjectStore(cfg *config.Config, redisCache *cache.Cache, logger *slog.Logger) (objectstore.ObjectStore, error) {
+	switch cfg.Storage.Backend {
+	case "", config.StorageBackendR2:
+		return r2.NewStorage(&cfg.R2, redisCache, logger)
+	case config.StorageBackendLocal:
+		return objectstore.NewLocal(cfg.Storage.LocalDir)
+	case config.StorageBackendMemory:
+		return objectstore.NewMemory(), nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", cfg.Storage.Backend)
+	}
+}
+
+// Run serves HTTP or runs the workers until SIGINT/SIGTERM and then shuts down gracefully
 func (a *App) Run() error {
-	log.Printf("starting server")
-	return a.HttpServer.ListenAndServe()
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	errCh := make(chan error, 1)
+	if a.HttpServer != nil {
+		go func() {
+			a.log.Info("starting server", "addr", a.HttpServer.Addr)
+			errCh <- a.HttpServer.ListenAndServe()
+		}()
+	}
+	if a.worker != nil {
+		go func() {
+			a.worker.Wait()
+			errCh <- errors.New("queue worker stopped")
+		}()
+	}
+
+	select {
+	case err := <-errCh:
+		_ = a.Shutdown()
+		return err
+	case <-ctx.Done():
+		a.log.Info("shutdown signal received")
+	}
+
+	return a.Shutdown()
+}
+
+// Shutdown stops accepting requests, waits for in-flight requests, and running jobs,
+// and closes connections, all within server.shutdown_timeout. Whatever is still running after that is abandoned;
+// unacknowledged jobs are reclaimed by another replica.
+func (a *App) Shutdown() error {
+	timeout := orDefault(a.cfg.Server.ShutdownTimeout.Duration(), defaultShutdownTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var err error
+	if a.HttpServer != nil {
+		if err = a.HttpServer.Shutdown(ctx); err != nil {
+			a.log.Error("http shutdown failed", "error", err)
+		}
+	}
+
+	a.stopWorkers()
+	if !waitFor(ctx, func() {
+		if a.worker != nil {
+			a.worker.Wait()
+		}
+		a.background.Wait()
+	}) {
+		a.log.Warn("shutdown deadline exceeded while waiting for workers")
+	}
+
+	if !waitFor(ctx, func() {
+		for _, c := range a.closers {
+			c()
+		}
+	}) {
+		a.log.Warn("shutdown deadline exceeded while closing storage")
+	}
+
+	a.stopRedis()
+	a.log.Info("shutdown complete")
+	return err
+}
+
+// waitFor runs fn and reports whether it finished before ctx expired
+func waitFor(ctx context.Context, fn func()) bool {
+	done := make(chan struct{})
+	go func() {
+		fn()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func orDefault(d, def time.Duration) time.Duration {
+	if d > 0 {
+		return d
+	}
+	return def
 }
@@ -2,7 +2,6 @@ package cache
 
 import (
 	"context"
-	"strconv"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -20,13 +19,8 @@ func (c *Cache) Get(ctx context.Context, key string) (interface{}, error) {
 }
 
 // Store data to Redis
-func (c *Cache) Store(ctx context.Context, key string, ttl int, value interface{}) error {
-	dur, err := time.ParseDuration(strconv.Itoa(ttl) + "s")
-	if err != nil {
-		return err
-	}
-
-	cmd := c.Redis.Set(ctx, c.Namespace+":"+key, value, dur)
+func (c *Cache) Store(ctx context.Context, key string, ttl time.Duration, value interface{}) error {
+	cmd := c.Redis.Set(ctx, c.Namespace+":"+key, value, ttl)
 	return cmd.Err()
 }
 
@@ -1,20 +1,139 @@
 package config
 
 import (
+	"encoding"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"os"
+	"reflect"
+	"strconv"
+	"strings"
 )
 
+// EnvPrefix starts the name of every environment override, see Read
+const EnvPrefix = "MEDIAHUB_"
+
 // Create new config instance
 func NewConfig() *Config {
 	return &Config{}
 }
 
-// Load configuration file in json format
+// Read loads the configuration file in json format, skipped when file is empty,
+// and then applies the environment overrides.
+//
+// Every field can be overridden by MEDIAHUB_<SECTION>_<FIELD>, named after the json keys,
+// e.g. MEDIAHUB_DATABASE_DSN, MEDIAHUB_R2_SECRET_KEY or MEDIAHUB_REDIS_PASSWORD.
+// MEDIAHUB_<SECTION>_<FIELD>_FILE reads the value from a file instead, for mounted secrets.
+// Lists and maps (redis.nodes, signing.keys, variants) take a json value.
 func (c *Config) Read(file string) error {
-	data, err := os.ReadFile(file)
-	if err == nil {
-		_ = json.Unmarshal(data, c)
+	if file != "" {
+		if err := c.readFile(file); err != nil {
+			return err
+		}
+	}
+	return applyEnv(reflect.ValueOf(c).Elem(), EnvPrefix)
+}
+
+func (c *Config) readFile(file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(c); err != nil {
+		return fmt.Errorf("config %s: %w", file, err)
+	}
+	if err := dec.Decode(&struct{}{}); !errors.Is(err, io.EOF) {
+		return fmt.Errorf("config %s: unexpected data after the top-level object", file)
+	}
+	return nil
+}
+
+func applyEnv(v reflect.Value, prefix string) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if !f.IsExported() || name == "" || name == "-" {
+			continue
+		}
+		key := prefix + strings.ToUpper(name)
+		field := v.Field(i)
+
+		if _, ok := field.Addr().Interface().(encoding.TextUnmarshaler); !ok && field.Kind() == reflect.Struct {
+			if err := applyEnv(field, key+"_"); err != nil {
+				return err
+			}
+			continue
+		}
+
+		value, ok, err := lookupEnv(key)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		if err := setField(field, value); err != nil {
+			return fmt.Errorf("config %s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// lookupEnv returns the value of key, or the content of the file named by key_FILE
+func lookupEnv(key string) (string, bool, error) {
+	value, ok := os.LookupEnv(key)
+	path, fromFile := os.LookupEnv(key + "_FILE")
+	if !fromFile {
+		return value, ok, nil
+	}
+	if ok {
+		return "", false, fmt.Errorf("config: both %s and %s_FILE are set", key, key)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", false, fmt.Errorf("config %s_FILE: %w", key, err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), true, nil
+}
+
+func setField(field reflect.Value, value string) error {
+	if u, ok := field.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return u.UnmarshalText([]byte(value))
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case reflect.Pointer:
+		elem := reflect.New(field.Type().Elem())
+		if err := setField(elem.Elem(), value); err != nil {
+			return err
+		}
+		field.Set(elem)
+	default:
+		// replace rather than merge into what the file set
+		field.Set(reflect.Zero(field.Type()))
+		return json.Unmarshal([]byte(value), field.Addr().Interface())
 	}
-	return err
+	return nil
 }
@@ -0,0 +1,247 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, data string) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(file, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestReadRejectsMalformedFiles(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr string
+	}{
+		{name: "unknown field", data: `{"server": {"prot": 8080}}`, wantErr: `unknown field "prot"`},
+		{name: "unknown section", data: `{"servers": {}}`, wantErr: `unknown field "servers"`},
+		{name: "trailing object", data: `{"server": {"port": 8080}} {}`, wantErr: "unexpected data after the top-level object"},
+		{name: "trailing garbage", data: `{"server": {"port": 8080}} x`, wantErr: "unexpected data after the top-level object"},
+		{name: "wrong type", data: `{"server": {"port": "8080"}}`, wantErr: "server.port"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := NewConfig().Read(writeConfig(t, tt.data))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Read() = %v, want an error mentioning %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestReadEnvOverrides(t *testing.T) {
+	file := writeConfig(t, `{
+		"server": {"port": 8080},
+		"database": {"dsn": "postgres://file/mediahub"},
+		"redis": {"nodes": [{"host": "a", "port": 6379}, {"host": "b", "port": 6379}]},
+		"webp_worker": {"stream": "webp", "workers": 2}
+	}`)
+
+	t.Setenv(EnvPrefix+"SERVER_PORT", "9090")
+	t.Setenv(EnvPrefix+"WEBP_WORKER_WORKERS", "8")
+	t.Setenv(EnvPrefix+"WEBP_WORKER_RECLAIM_INTERVAL", "1m")
+	t.Setenv(EnvPrefix+"R2_MAX_RETRIES", "0")
+	t.Setenv(EnvPrefix+"SIGNING_REQUIRED", "false")
+	t.Setenv(EnvPrefix+"REDIS_NODES", `[{"host": "c", "port": 7000}]`)
+	t.Setenv(EnvPrefix+"VARIANTS", `{"shop": [{"name": "thumb", "width": 150}]}`)
+
+	cfg := NewConfig()
+	if err := cfg.Read(file); err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+
+	if cfg.Server.Port != 9090 {
+		t.Errorf("server.port = %d, want 9090", cfg.Server.Port)
+	}
+	if cfg.Database.DSN != "postgres://file/mediahub" || cfg.WebP.Stream != "webp" {
+		t.Errorf("settings without an override changed: dsn %q, stream %q", cfg.Database.DSN, cfg.WebP.Stream)
+	}
+	if cfg.WebP.Workers != 8 || cfg.WebP.ReclaimInterval.Duration() != time.Minute {
+		t.Errorf("webp_worker = %d workers, reclaim %v, want 8 and 1m", cfg.WebP.Workers, cfg.WebP.ReclaimInterval)
+	}
+	if cfg.R2.MaxRetries == nil || cfg.R2.Retries() != 0 {
+		t.Errorf("r2.max_retries = %v, want an explicit 0", cfg.R2.MaxRetries)
+	}
+	if cfg.Signing.Required == nil || *cfg.Signing.Required {
+		t.Errorf("signing.required = %v, want an explicit false", cfg.Signing.Required)
+	}
+	// a json value replaces the list of the file instead of merging into it
+	if len(cfg.Redis.Nodes) != 1 || cfg.Redis.Nodes[0] != (RedisNode{Host: "c", Port: 7000}) {
+		t.Errorf("redis.nodes = %+v, want only c:7000", cfg.Redis.Nodes)
+	}
+	if presets := cfg.Variants["shop"]; len(presets) != 1 || presets[0].Name != "thumb" || presets[0].Width != 150 {
+		t.Errorf("variants = %+v", cfg.Variants)
+	}
+}
+
+func TestReadEnvOnly(t *testing.T) {
+	t.Setenv(EnvPrefix+"DATABASE_DSN", "postgres://env/mediahub")
+
+	cfg := NewConfig()
+	if err := cfg.Read(""); err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if cfg.Database.DSN != "postgres://env/mediahub" {
+		t.Errorf("database.dsn = %q", cfg.Database.DSN)
+	}
+}
+
+func TestReadEnvRejectsInvalidValues(t *testing.T) {
+	for key, value := range map[string]string{
+		"SERVER_PORT":    "http",
+		"SIGNING_KEYS":   `[{"id": "a"`,
+		"REDIS_NODES":    `{"host": "a"}`,
+		"R2_MAX_RETRIES": "three",
+	} {
+		t.Run(key, func(t *testing.T) {
+			t.Setenv(EnvPrefix+key, value)
+			err := NewConfig().Read("")
+			if err == nil || !strings.Contains(err.Error(), EnvPrefix+key) {
+				t.Errorf("Read() = %v, want an error naming %s", err, EnvPrefix+key)
+			}
+		})
+	}
+}
+
+func TestReadSecretFiles(t *testing.T) {
+	dir := t.TempDir()
+	secret := filepath.Join(dir, "r2")
+	// mounted secrets usually end with a newline
+	if err := os.WriteFile(secret, []byte("s3cr3t\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	keys := filepath.Join(dir, "keys")
+	if err := os.WriteFile(keys, []byte(`[{"id": "a", "secret": "x"}]`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv(EnvPrefix+"R2_SECRET_KEY_FILE", secret)
+	t.Setenv(EnvPrefix+"SIGNING_KEYS_FILE", keys)
+
+	cfg := NewConfig()
+	if err := cfg.Read(writeConfig(t, `{"r2": {"secret_key": "from-file"}}`)); err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if cfg.R2.SecretKey != "s3cr3t" {
+		t.Errorf("r2.secret_key = %q, want s3cr3t", cfg.R2.SecretKey)
+	}
+	if len(cfg.Signing.Keys) != 1 || cfg.Signing.Keys[0] != (SigningKey{ID: "a", Secret: "x"}) {
+		t.Errorf("signing.keys = %+v", cfg.Signing.Keys)
+	}
+}
+
+func TestReadSecretFileErrors(t *testing.T) {
+	secret := filepath.Join(t.TempDir(), "r2")
+	if err := os.WriteFile(secret, []byte("s3cr3t"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("value and file", func(t *testing.T) {
+		t.Setenv(EnvPrefix+"R2_SECRET_KEY", "inline")
+		t.Setenv(EnvPrefix+"R2_SECRET_KEY_FILE", secret)
+		err := NewConfig().Read("")
+		if err == nil || !strings.Contains(err.Error(), "both "+EnvPrefix+"R2_SECRET_KEY and "+EnvPrefix+"R2_SECRET_KEY_FILE are set") {
+			t.Errorf("Read() = %v, want an error about both variables", err)
+		}
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		t.Setenv(EnvPrefix+"R2_SECRET_KEY_FILE", filepath.Join(t.TempDir(), "missing"))
+		err := NewConfig().Read("")
+		if err == nil || !strings.Contains(err.Error(), EnvPrefix+"R2_SECRET_KEY_FILE") {
+			t.Errorf("Read() = %v, want an error naming the variable", err)
+		}
+	})
+}
+
+func TestValidateByCommand(t *testing.T) {
+	migrateOnly := NewConfig()
+	migrateOnly.Database.DSN = "postgres://localhost/mediahub"
+
+	if err := migrateOnly.ValidateMigrate(); err != nil {
+		t.Errorf("ValidateMigrate() = %v, want nil with only database.dsn set", err)
+	}
+	if err := NewConfig().ValidateMigrate(); err == nil || !strings.Contains(err.Error(), "database.dsn is required") {
+		t.Errorf("ValidateMigrate() = %v, want database.dsn to be required", err)
+	}
+	if err := migrateOnly.Validate(); err == nil || !strings.Contains(err.Error(), "r2.bucket_name is required") {
+		t.Errorf("Validate() = %v, want the serve and worker settings to be required", err)
+	}
+
+	dlq := NewConfig()
+	dlq.Redis.Nodes = []RedisNode{{Host: "localhost", Port: 6379}}
+	dlq.Redis.HealthCheckInterval = Duration(10 * time.Second)
+	dlq.WebP = WebPWorkerConfig{Stream: "webp", Group: "webp", Consumer: "worker", Workers: 1, MaxAttempts: 3}
+
+	if err := dlq.ValidateDLQ(); err != nil {
+		t.Errorf("ValidateDLQ() = %v, want nil without database, r2 and upload settings", err)
+	}
+	if err := NewConfig().ValidateDLQ(); err == nil || !strings.Contains(err.Error(), "redis.nodes") || !strings.Contains(err.Error(), "webp_worker.stream") {
+		t.Errorf("ValidateDLQ() = %v, want redis and webp_worker to be required", err)
+	}
+}
+
+func TestValidateSigningDefaultTTL(t *testing.T) {
+	cfg := NewConfig()
+	cfg.Signing.DefaultTTL = Duration(MaxSignedURLTTL)
+	var v validator
+	cfg.checkSigning(&v)
+	if err := v.err(); err != nil {
+		t.Errorf("default_ttl of %s: %v", MaxSignedURLTTL, err)
+	}
+
+	cfg.Signing.DefaultTTL = Duration(MaxSignedURLTTL + time.Second)
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "signing.default_ttl can't exceed") {
+		t.Errorf("Validate() = %v, want default_ttl to be bounded", err)
+	}
+}
+
+func TestValidateVariantSizes(t *testing.T) {
+	tests := []struct {
+		name         string
+		maxDimension int
+		preset       VariantPreset
+		wantErr      string
+	}{
+		{name: "default limit", preset: VariantPreset{Name: "big", Width: 4096, Height: 4096}},
+		{name: "above the default limit", preset: VariantPreset{Name: "big", Width: 4097}, wantErr: "can't exceed transform.max_dimension 4096"},
+		{name: "raised limit", maxDimension: 8000, preset: VariantPreset{Name: "big", Height: 8000}},
+		{name: "beyond smallint", maxDimension: 8000, preset: VariantPreset{Name: "big", Width: 40000}, wantErr: "can't exceed transform.max_dimension 8000"},
+		{name: "negative", preset: VariantPreset{Name: "big", Width: -1}, wantErr: "can't be negative"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := NewConfig()
+			cfg.Transform.MaxDimension = tt.maxDimension
+			cfg.Variants = VariantsConfig{"shop": {tt.preset}}
+
+			var v validator
+			cfg.checkVariants(&v)
+			err := v.err()
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("checkVariants() = %v, want nil", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("checkVariants() = %v, want an error mentioning %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	cfg := NewConfig()
+	cfg.Transform.MaxDimension = 40000
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "transform.max_dimension must be between 0 and 32767") {
+		t.Errorf("Validate() = %v, want max_dimension to fit a SMALLINT", err)
+	}
+}
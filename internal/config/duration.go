@@ -0,0 +1,77 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Duration is a time.Duration read from a string like "30s", "5m" or "1h30m".
+// A bare number is a number of seconds, which is what the numeric fields used before.
+type Duration time.Duration
+
+func (d Duration) Duration() time.Duration { return time.Duration(d) }
+
+func (d Duration) String() string { return time.Duration(d).String() }
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		var secs float64
+		if err := json.Unmarshal(data, &secs); err != nil {
+			return fmt.Errorf("duration must be a string like \"30s\" or a number of seconds, got %s", data)
+		}
+		*d = Duration(secs * float64(time.Second))
+		return nil
+	}
+	return d.UnmarshalText([]byte(s))
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	s := string(text)
+	if secs, err := strconv.ParseFloat(s, 64); err == nil {
+		*d = Duration(secs * float64(time.Second))
+		return nil
+	}
+
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q, want e.g. \"30s\" or \"5m\"", s)
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// TextDuration is a Duration that must be written with a unit, e.g. "5s".
+// The fields using it were nanoseconds before Duration existed, so a bare number
+// is rejected instead of being read as seconds, 5000000000 would be 158 years.
+type TextDuration Duration
+
+func (d TextDuration) Duration() time.Duration { return time.Duration(d) }
+
+func (d TextDuration) String() string { return time.Duration(d).String() }
+
+func (d TextDuration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *TextDuration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string with a unit like \"5s\", got %s", data)
+	}
+	return d.UnmarshalText([]byte(s))
+}
+
+func (d *TextDuration) UnmarshalText(text []byte) error {
+	s := string(text)
+	if _, err := strconv.ParseFloat(s, 64); err == nil {
+		return fmt.Errorf("duration %q needs a unit like \"5s\", a bare number used to mean nanoseconds here", s)
+	}
+	return (*Duration)(d).UnmarshalText(text)
+}
@@ -0,0 +1,194 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDurationUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    time.Duration
+		wantErr bool
+	}{
+		{in: `"30s"`, want: 30 * time.Second},
+		{in: `"5m"`, want: 5 * time.Minute},
+		{in: `"1h30m"`, want: 90 * time.Minute},
+		{in: `"250ms"`, want: 250 * time.Millisecond},
+		{in: `30`, want: 30 * time.Second},
+		{in: `"30"`, want: 30 * time.Second},
+		{in: `1.5`, want: 1500 * time.Millisecond},
+		{in: `"1.5"`, want: 1500 * time.Millisecond},
+		{in: `0`, want: 0},
+		{in: `"0s"`, want: 0},
+		{in: `-2`, want: -2 * time.Second},
+		{in: `"-1m"`, want: -time.Minute},
+		{in: `"30 s"`, wantErr: true},
+		{in: `"thirty"`, wantErr: true},
+		{in: `"5d"`, wantErr: true},
+		{in: `""`, wantErr: true},
+		{in: `true`, wantErr: true},
+		{in: `{}`, wantErr: true},
+		{in: `[30]`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			var d Duration
+			err := json.Unmarshal([]byte(tt.in), &d)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Unmarshal(%s) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			}
+			if !tt.wantErr && d.Duration() != tt.want {
+				t.Errorf("Unmarshal(%s) = %v, want %v", tt.in, d.Duration(), tt.want)
+			}
+		})
+	}
+}
+
+func TestDurationUnmarshalText(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    time.Duration
+		wantErr bool
+	}{
+		{in: "30s", want: 30 * time.Second},
+		{in: "30", want: 30 * time.Second},
+		{in: "0.25", want: 250 * time.Millisecond},
+		{in: "-1s", want: -time.Second},
+		{in: "", wantErr: true},
+		{in: "soon", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			var d Duration
+			err := d.UnmarshalText([]byte(tt.in))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("UnmarshalText(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			}
+			if !tt.wantErr && d.Duration() != tt.want {
+				t.Errorf("UnmarshalText(%q) = %v, want %v", tt.in, d.Duration(), tt.want)
+			}
+		})
+	}
+}
+
+func TestDurationMarshalJSON(t *testing.T) {
+	data, err := json.Marshal(Duration(90 * time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `"1m30s"` {
+		t.Errorf("Marshal = %s, want \"1m30s\"", data)
+	}
+
+	var back Duration
+	if err := json.Unmarshal(data, &back); err != nil || back.Duration() != 90*time.Second {
+		t.Errorf("round trip = %v, %v, want 1m30s", back, err)
+	}
+}
+
+// The redis durations were plain numbers multiplied by time.Second before Duration existed
+func TestDurationKeepsNumericRedisSeconds(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.json")
+	data := `{"redis": {"health_check_interval": 10, "dial_timeout": 5, "read_timeout": 3, "write_timeout": 3}}`
+	if err := os.WriteFile(file, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(EnvPrefix+"REDIS_WRITE_TIMEOUT", "4")
+
+	cfg := NewConfig()
+	if err := cfg.Read(file); err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+
+	for _, tt := range []struct {
+		name string
+		got  Duration
+		want time.Duration
+	}{
+		{"health_check_interval", cfg.Redis.HealthCheckInterval, 10 * time.Second},
+		{"dial_timeout", cfg.Redis.DialTimeout, 5 * time.Second},
+		{"read_timeout", cfg.Redis.ReadTimeout, 3 * time.Second},
+		{"write_timeout", cfg.Redis.WriteTimeout, 4 * time.Second}, // from the environment
+	} {
+		if tt.got.Duration() != tt.want {
+			t.Errorf("redis.%s = %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+// The server and webp_worker durations were nanoseconds before Duration existed,
+// a bare number there must fail instead of turning 5000000000 into 158 years
+func TestDurationRejectsNumericNanoseconds(t *testing.T) {
+	for _, field := range []string{
+		`"server": {"read_timeout": 5000000000}`,
+		`"server": {"write_timeout": 5000000000}`,
+		`"webp_worker": {"backoff_base": 1000000000}`,
+		`"webp_worker": {"block_timeout": 5000000000}`,
+		`"webp_worker": {"block_timeout": "5000000000"}`,
+	} {
+		file := filepath.Join(t.TempDir(), "config.json")
+		if err := os.WriteFile(file, []byte("{"+field+"}"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := NewConfig().Read(file); err == nil || !strings.Contains(err.Error(), "unit") {
+			t.Errorf("Read(%s) error = %v, want a missing unit error", field, err)
+		}
+	}
+
+	t.Setenv(EnvPrefix+"WEBP_WORKER_BLOCK_TIMEOUT", "5000000000")
+	if err := NewConfig().Read(""); err == nil {
+		t.Error("Read with a numeric block_timeout in the environment succeeded, want an error")
+	}
+}
+
+func TestDurationWithUnitForFormerNanoseconds(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.json")
+	data := `{"server": {"read_timeout": "30s", "write_timeout": "1m"}, "webp_worker": {"backoff_base": "1s", "block_timeout": "5s"}}`
+	if err := os.WriteFile(file, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(EnvPrefix+"WEBP_WORKER_BACKOFF_BASE", "500ms")
+
+	cfg := NewConfig()
+	if err := cfg.Read(file); err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+
+	for _, tt := range []struct {
+		name string
+		got  TextDuration
+		want time.Duration
+	}{
+		{"server.read_timeout", cfg.Server.ReadTimeout, 30 * time.Second},
+		{"server.write_timeout", cfg.Server.WriteTimeout, time.Minute},
+		{"webp_worker.backoff_base", cfg.WebP.BackoffBase, 500 * time.Millisecond}, // from the environment
+		{"webp_worker.block_timeout", cfg.WebP.BlockTimeout, 5 * time.Second},
+	} {
+		if tt.got.Duration() != tt.want {
+			t.Errorf("%s = %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+func TestValidateRejectsNegativeDurations(t *testing.T) {
+	cfg := NewConfig()
+	cfg.Redis.DialTimeout = Duration(-time.Second)
+	cfg.Purge.Retention = Duration(-time.Hour)
+
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("Validate() = nil, want an error")
+	}
+	for _, want := range []string{"redis.dial_timeout can't be negative", "purge.retention can't be negative"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Validate() = %v, want it to mention %q", err, want)
+		}
+	}
+}
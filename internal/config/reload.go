@@ -0,0 +1,217 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// reloadPollInterval is how often the Reloader checks the config file for changes
+const reloadPollInterval = 5 * time.Second
+
+// liveFields are the settings applied without a restart. A path ending in "." covers the whole section.
+var liveFields = []string{
+	"upload.",
+	"webp_worker.workers",
+	"r2.max_retries",
+	"r2.retry_base_delay",
+}
+
+// Reloader re-reads the configuration when the file changes or the process gets SIGHUP.
+// A new configuration is applied only if it is valid and every change is in liveFields,
+// otherwise it is rejected as a whole and the running configuration stays in effect.
+type Reloader struct {
+	file string
+	log  *slog.Logger
+
+	mu          sync.Mutex
+	current     *Config
+	raw         []byte
+	subscribers []func(*Config)
+}
+
+func NewReloader(file string, current *Config, logger *slog.Logger) *Reloader {
+	r := &Reloader{file: file, current: current, log: logger.With("component", "config")}
+	if file != "" {
+		r.raw, _ = os.ReadFile(file)
+	}
+	return r
+}
+
+// Subscribe registers fn to be called with every accepted configuration.
+// The Config passed to fn must not be modified.
+func (r *Reloader) Subscribe(fn func(*Config)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.subscribers = append(r.subscribers, fn)
+}
+
+// Run watches the file and SIGHUP until ctx is canceled
+func (r *Reloader) Run(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	t := time.NewTicker(reloadPollInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			r.log.Info("SIGHUP received, reloading")
+			if err := r.Reload(); err != nil {
+				r.log.Warn("reload rejected", "error", err)
+			}
+		case <-t.C:
+			if !r.fileChanged() {
+				continue
+			}
+			r.log.Info("file changed, reloading", "file", r.file)
+			if err := r.Reload(); err != nil {
+				r.log.Warn("reload rejected", "error", err)
+			}
+		}
+	}
+}
+
+func (r *Reloader) fileChanged() bool {
+	if r.file == "" {
+		return false
+	}
+	data, err := os.ReadFile(r.file)
+	if err != nil {
+		return false
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return !bytes.Equal(data, r.raw)
+}
+
+// Reload reads and validates the configuration and applies it when every change can be applied live
+func (r *Reloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var raw []byte
+	if r.file != "" {
+		var err error
+		if raw, err = os.ReadFile(r.file); err != nil {
+			return err
+		}
+	}
+	// a rejected file is not retried until it changes again
+	r.raw = raw
+
+	next := NewConfig()
+	if err := next.Read(r.file); err != nil {
+		return err
+	}
+	if err := next.Validate(); err != nil {
+		return err
+	}
+
+	changes, err := diff(r.current, next)
+	if err != nil {
+		return err
+	}
+	if len(changes) == 0 {
+		r.log.Info("no changes")
+		return nil
+	}
+
+	var restart []string
+	for _, c := range changes {
+		if !isLive(c.path) {
+			restart = append(restart, c.path)
+		}
+	}
+	if len(restart) > 0 {
+		return fmt.Errorf("changes to %s need a restart", strings.Join(restart, ", "))
+	}
+
+	for _, c := range changes {
+		r.log.Info("setting changed", "setting", c.path, "from", c.from, "to", c.to)
+	}
+	r.current = next
+	for _, fn := range r.subscribers {
+		fn(next)
+	}
+	return nil
+}
+
+func isLive(path string) bool {
+	return slices.ContainsFunc(liveFields, func(f string) bool {
+		return path == f || strings.HasSuffix(f, ".") && strings.HasPrefix(path, f)
+	})
+}
+
+type change struct {
+	path     string
+	from, to string
+}
+
+// diff lists the changed settings by json path, e.g. "webp_worker.workers".
+// Lists and maps compare as a whole.
+func diff(a, b *Config) ([]change, error) {
+	fa, err := flatten(a)
+	if err != nil {
+		return nil, err
+	}
+	fb, err := flatten(b)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []change
+	for path, from := range fa {
+		if to := fb[path]; to != from {
+			changes = append(changes, change{path: path, from: from, to: to})
+		}
+	}
+	for path, to := range fb {
+		if _, ok := fa[path]; !ok {
+			changes = append(changes, change{path: path, to: to})
+		}
+	}
+	slices.SortFunc(changes, func(x, y change) int { return strings.Compare(x.path, y.path) })
+	return changes, nil
+}
+
+func flatten(c *Config) (map[string]string, error) {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	var tree map[string]any
+	if err := json.Unmarshal(data, &tree); err != nil {
+		return nil, err
+	}
+
+	out := make(map[string]string)
+	var walk func(prefix string, v map[string]any)
+	walk = func(prefix string, v map[string]any) {
+		for k, val := range v {
+			// variants is keyed by project, compare it as a whole
+			if m, ok := val.(map[string]any); ok && prefix+k != "variants" {
+				walk(prefix+k+".", m)
+				continue
+			}
+			s, _ := json.Marshal(val)
+			out[prefix+k] = string(s)
+		}
+	}
+	walk("", tree)
+	return out, nil
+}
@@ -0,0 +1,161 @@
+package config
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const reloadBase = `{
+	"server": {"port": 8080},
+	"database": {"dsn": "postgres://localhost/mediahub"},
+	"storage": {"backend": "memory"},
+	"upload": {"max_request_body": 10, "max_multipart_memory": 8},
+	"redis": {"nodes": [{"host": "localhost", "port": 6379}], "health_check_interval": "10s"},
+	"webp_worker": {"stream": "webp", "group": "webp", "consumer": "worker", "workers": 2, "max_attempts": 3}
+}`
+
+func TestIsLive(t *testing.T) {
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"upload.max_request_body", true},
+		{"upload.allowed_mime_types", true},
+		{"webp_worker.workers", true},
+		{"r2.max_retries", true},
+		{"r2.retry_base_delay", true},
+		{"upload", false},
+		{"uploads.max_request_body", false},
+		{"webp_worker.workers_extra", false},
+		{"webp_worker.stream", false},
+		{"r2.bucket_name", false},
+		{"database.dsn", false},
+	}
+
+	for _, tt := range tests {
+		if got := isLive(tt.path); got != tt.want {
+			t.Errorf("isLive(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestDiff(t *testing.T) {
+	retries := 0
+	a := NewConfig()
+	a.Server.Port = 8080
+	a.Redis.Nodes = []RedisNode{{Host: "a", Port: 6379}}
+
+	b := NewConfig()
+	b.Server.Port = 9090
+	b.Redis.Nodes = []RedisNode{{Host: "b", Port: 6379}}
+	b.R2.MaxRetries = &retries
+
+	changes, err := diff(a, b)
+	if err != nil {
+		t.Fatalf("diff: %v", err)
+	}
+	want := []change{
+		{path: "r2.max_retries", from: "null", to: "0"},
+		{path: "redis.nodes", from: `[{"host":"a","port":6379}]`, to: `[{"host":"b","port":6379}]`},
+		{path: "server.port", from: "8080", to: "9090"},
+	}
+	if len(changes) != len(want) {
+		t.Fatalf("diff = %+v, want %+v", changes, want)
+	}
+	for i := range want {
+		if changes[i] != want[i] {
+			t.Errorf("diff[%d] = %+v, want %+v", i, changes[i], want[i])
+		}
+	}
+
+	if changes, err := diff(a, a); err != nil || len(changes) != 0 {
+		t.Errorf("diff of equal configs = %+v, %v, want none", changes, err)
+	}
+}
+
+func TestReload(t *testing.T) {
+	tests := []struct {
+		name    string
+		old     string // replaced in reloadBase by new
+		new     string
+		applied bool
+		wantErr string
+	}{
+		{
+			name:    "live change",
+			old:     `"max_request_body": 10`,
+			new:     `"max_request_body": 20`,
+			applied: true,
+		},
+		{
+			name:    "live workers change",
+			old:     `"workers": 2`,
+			new:     `"workers": 4`,
+			applied: true,
+		},
+		{
+			name:    "restart only change",
+			old:     `"postgres://localhost/mediahub"`,
+			new:     `"postgres://db/mediahub"`,
+			wantErr: "database.dsn need a restart",
+		},
+		{
+			name:    "invalid config",
+			old:     `"workers": 2`,
+			new:     `"workers": 0`,
+			wantErr: "webp_worker.workers must be positive",
+		},
+		{
+			name:    "unsupported mime type",
+			old:     `"max_multipart_memory": 8`,
+			new:     `"max_multipart_memory": 8, "allowed_mime_types": ["image/gif"]`,
+			wantErr: `"image/gif" can't be decoded`,
+		},
+		{name: "no change"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "config.json")
+			if err := os.WriteFile(file, []byte(reloadBase), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			current := NewConfig()
+			if err := current.Read(file); err != nil {
+				t.Fatalf("Read: %v", err)
+			}
+
+			r := NewReloader(file, current, slog.New(slog.NewTextHandler(io.Discard, nil)))
+			var got *Config
+			r.Subscribe(func(c *Config) { got = c })
+
+			edited := strings.Replace(reloadBase, tt.old, tt.new, 1)
+			if err := os.WriteFile(file, []byte(edited), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			err := r.Reload()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Reload() = %v, want an error mentioning %q", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("Reload: %v", err)
+			}
+
+			if (got != nil) != tt.applied {
+				t.Fatalf("subscriber called = %v, want %v", got != nil, tt.applied)
+			}
+			if tt.applied && r.current != got {
+				t.Error("current config is not the one passed to the subscribers")
+			}
+			if !tt.applied && r.current != current {
+				t.Error("current config was replaced")
+			}
+		})
+	}
+}
@@ -2,28 +2,56 @@ package config
 
 import (
 	"fmt"
+	"regexp"
+	"slices"
 	"time"
 )
 
 type Config struct {
-	Server   ServerConfig     `json:"server"`
-	Upload   UploadConfig     `json:"upload"`
-	Database Database         `json:"database"`
-	Redis    RedisConfig      `json:"redis"`
-	R2       R2Config         `json:"r2"`
-	WebP     WebPWorkerConfig `json:"webp_worker"`
-	Sentry   SentryConfig     `json:"sentry"`
+	Server    ServerConfig     `json:"server"`
+	Upload    UploadConfig     `json:"upload"`
+	Database  Database         `json:"database"`
+	Redis     RedisConfig      `json:"redis"`
+	Storage   StorageConfig    `json:"storage"`
+	R2        R2Config         `json:"r2"`
+	WebP      WebPWorkerConfig `json:"webp_worker"`
+	Purge     PurgeConfig      `json:"purge"`
+	Transform TransformConfig  `json:"transform"`
+	Signing   SigningConfig    `json:"signing"`
+	Variants  VariantsConfig   `json:"variants"`
+	Admin     AdminConfig      `json:"admin"`
+	Metrics   MetricsConfig    `json:"metrics"`
+	Tracing   TracingConfig    `json:"tracing"`
+	Log       LogConfig        `json:"log"`
+	Sentry    SentryConfig     `json:"sentry"`
 }
 
 type ServerConfig struct {
-	Port         int           `json:"port"`
-	ReadTimeout  time.Duration `json:"read_timeout"`
-	WriteTimeout time.Duration `json:"write_timeout"`
+	Port              int          `json:"port"`
+	ReadTimeout       TextDuration `json:"read_timeout"`        // whole request including the body, 0 means no limit
+	ReadHeaderTimeout Duration     `json:"read_header_timeout"` // request headers, defaults to 10s
+	WriteTimeout      TextDuration `json:"write_timeout"`       // from the end of the headers to the end of the response
+	IdleTimeout       Duration     `json:"idle_timeout"`        // keep-alive connections, defaults to 2m
+	ShutdownTimeout   Duration     `json:"shutdown_timeout"`    // graceful shutdown deadline, defaults to 30s
 }
 
+// UploadConfig is applied live on reload
 type UploadConfig struct {
-	MaxRequestBodyMB     int64 `json:"max_request_body"`
-	MaxMultipartMemoryMB int64 `json:"max_multipart_memory"`
+	MaxRequestBodyMB     int64    `json:"max_request_body"`
+	MaxMultipartMemoryMB int64    `json:"max_multipart_memory"`
+	AllowedMIMETypes     []string `json:"allowed_mime_types"` // subset of image/png, image/jpeg and image/webp, defaults to all three
+}
+
+// DecodableMIMETypes are the upload types the image pipeline can decode, and the default of AllowedMIMETypes
+var DecodableMIMETypes = []string{"image/png", "image/jpeg", "image/webp"}
+
+// AllowsMIMEType reports whether uploads of the detected type are accepted
+func (c UploadConfig) AllowsMIMEType(mimeType string) bool {
+	allowed := c.AllowedMIMETypes
+	if len(allowed) == 0 {
+		allowed = DecodableMIMETypes
+	}
+	return slices.Contains(allowed, mimeType)
 }
 
 type Database struct {
@@ -31,14 +59,14 @@ type Database struct {
 }
 
 type RedisConfig struct {
-	Password            string        `json:"password"`
-	DatabaseID          int           `json:"database_id"`
-	HealthCheckInterval time.Duration `json:"health_check_interval"`
-	DialTimeout         time.Duration `json:"dial_timeout"`
-	ReadTimeout         time.Duration `json:"read_timeout"`
-	WriteTimeout        time.Duration `json:"write_timeout"`
-	PoolSize            int           `json:"pool_size"`
-	Nodes               []RedisNode   `json:"nodes"`
+	Password            string      `json:"password"`
+	DatabaseID          int         `json:"database_id"`
+	HealthCheckInterval Duration    `json:"health_check_interval"`
+	DialTimeout         Duration    `json:"dial_timeout"`
+	ReadTimeout         Duration    `json:"read_timeout"`
+	WriteTimeout        Duration    `json:"write_timeout"`
+	PoolSize            int         `json:"pool_size"`
+	Nodes               []RedisNode `json:"nodes"`
 }
 
 type RedisNode struct {
@@ -48,23 +76,185 @@ type RedisNode struct {
 
 func (n RedisNode) Addr() string { return fmt.Sprintf("%s:%d", n.Host, n.Port) }
 
+// Object storage backends selectable in StorageConfig.Backend
+const (
+	StorageBackendR2     = "r2"
+	StorageBackendLocal  = "local"
+	StorageBackendMemory = "memory"
+)
+
+type StorageConfig struct {
+	Backend  string `json:"backend"`   // "r2" (default), "local" or "memory"
+	LocalDir string `json:"local_dir"` // root directory of the local backend
+}
+
+// R2Config configures the S3 compatible object storage.
+// Besides Cloudflare R2 it works with MinIO, Ceph RGW or AWS S3 by setting Endpoint and Region.
 type R2Config struct {
-	AccountID   string `json:"account_id"`
+	AccountID   string `json:"account_id"` // R2 account, used to build the endpoint when Endpoint is empty
 	BucketName  string `json:"bucket_name"`
 	AccessKeyID string `json:"access_key_id"`
 	SecretKey   string `json:"secret_key"`
-	Endpoint    string `json:"endpoint"`
+	Endpoint    string `json:"endpoint"` // e.g. http://localhost:9000 for MinIO, empty for AWS S3 without account_id
+	Region      string `json:"region"`   // defaults to "auto" (R2)
+
+	VirtualHostedStyle bool `json:"virtual_hosted_style"` // use <bucket>.<host> addressing instead of path style
+	InsecureSkipVerify bool `json:"insecure_skip_verify"` // skip TLS verification, local setups only
+
+	MaxRetries     *int     `json:"max_retries"`      // retries of a failed upload, defaults to 3, 0 disables them, applied live on reload
+	RetryBaseDelay Duration `json:"retry_base_delay"` // first retry delay, doubled per retry, defaults to 300ms, applied live on reload
+}
+
+// Retries returns how often a failed upload is retried
+func (c R2Config) Retries() int {
+	if c.MaxRetries != nil {
+		return *c.MaxRetries
+	}
+	return 3
 }
 
 type WebPWorkerConfig struct {
-	Stream       string        `json:"stream"`        // redis stream name
-	Group        string        `json:"group"`         // consumer group name
-	Workers      int           `json:"workers"`       // number of concurrent goroutines
-	MaxAttempts  int           `json:"max_attempts"`  // max retries before DLQ
-	MaxLen       int64         `json:"max_len"`       // stream max length before trim
-	BackoffBase  time.Duration `json:"backoff_base"`  // base retry delay
-	BlockTimeout time.Duration `json:"block_timeout"` // XREADGROUP block timeout
-	Consumer     string        `json:"consumer"`
+	Stream       string       `json:"stream"`        // redis stream name
+	Group        string       `json:"group"`         // consumer group name
+	Workers      int          `json:"workers"`       // number of concurrent goroutines, applied live on reload
+	MaxAttempts  int          `json:"max_attempts"`  // max retries before DLQ
+	MaxLen       int64        `json:"max_len"`       // stream max length before trim
+	BackoffBase  TextDuration `json:"backoff_base"`  // base retry delay
+	BlockTimeout TextDuration `json:"block_timeout"` // XREADGROUP block timeout
+	Consumer     string       `json:"consumer"`      // base name, each process adds its host name and pid
+
+	DelayedSet       string `json:"delayed_set"`         // sorted set of jobs waiting for a retry, defaults to <stream>:delayed
+	DeadLetterStream string `json:"dead_letter_stream"`  // stream of jobs that failed for good, defaults to <stream>:dlq
+	DeadLetterMaxLen int64  `json:"dead_letter_max_len"` // dead letters kept before the oldest are trimmed, 0 keeps all of them
+
+	ReclaimInterval Duration `json:"reclaim_interval"` // how often stuck pending messages are reclaimed, defaults to 30s
+	MaxDeliveries   int      `json:"max_deliveries"`   // deliveries after which a pending message counts as poison, defaults to max_attempts
+}
+
+func (c WebPWorkerConfig) DelayedKey() string {
+	if c.DelayedSet != "" {
+		return c.DelayedSet
+	}
+	return c.Stream + ":delayed"
+}
+
+func (c WebPWorkerConfig) DeadLetterKey() string {
+	if c.DeadLetterStream != "" {
+		return c.DeadLetterStream
+	}
+	return c.Stream + ":dlq"
+}
+
+type PurgeConfig struct {
+	Retention Duration `json:"retention"`  // how long soft-deleted images are kept before purge
+	Interval  Duration `json:"interval"`   // how often the purge job runs
+	BatchSize int      `json:"batch_size"` // images purged per transaction
+}
+
+type TransformConfig struct {
+	MaxDimension   int      `json:"max_dimension"`   // largest accepted width/height, defaults to 4096
+	DefaultQuality int      `json:"default_quality"` // jpeg/webp quality when q is omitted, defaults to 80
+	CacheTTL       Duration `json:"cache_ttl"`       // how long a derived image stays in the redis cache
+	CacheMaxBytes  int      `json:"cache_max_bytes"` // larger derived images are only kept in object storage
+}
+
+// MaxSize returns the largest accepted width/height
+func (c TransformConfig) MaxSize() int {
+	if c.MaxDimension > 0 {
+		return c.MaxDimension
+	}
+	return 4096
+}
+
+// Quality returns the quality used when the request doesn't specify one
+func (c TransformConfig) Quality() int {
+	if c.DefaultQuality > 0 {
+		return c.DefaultQuality
+	}
+	return 80
+}
+
+// SigningConfig configures HMAC signed /img URLs.
+// Rotate by adding a new key, switching ActiveKey to it and removing the old key once its URLs expired.
+// MaxSignedURLTTL bounds how long a signed URL stays valid, a rotated key can be removed after that long
+const MaxSignedURLTTL = 7 * 24 * time.Hour
+
+type SigningConfig struct {
+	Keys       []SigningKey `json:"keys"`
+	ActiveKey  string       `json:"active_key"`  // id of the key that signs new URLs, defaults to the first key
+	Required   *bool        `json:"required"`    // reject unsigned /img requests, defaults to true when keys are configured
+	DefaultTTL Duration     `json:"default_ttl"` // how long a signed URL stays valid when the request doesn't say, at most 168h
+}
+
+// RequireSigned reports whether unsigned /img requests are rejected
+func (c SigningConfig) RequireSigned() bool {
+	if c.Required != nil {
+		return *c.Required
+	}
+	return len(c.Keys) > 0
+}
+
+type SigningKey struct {
+	ID     string `json:"id"`
+	Secret string `json:"secret"`
+}
+
+// VariantsConfig maps a project to the variants generated for each of its uploads.
+// The "*" entry applies to projects without an entry of their own.
+type VariantsConfig map[string][]VariantPreset
+
+// For returns the presets of a project
+func (c VariantsConfig) For(project string) []VariantPreset {
+	if presets, ok := c[project]; ok {
+		return presets
+	}
+	return c["*"]
+}
+
+// variantNamePattern fits image_variants.name VARCHAR(32) and is safe as an object key segment
+var variantNamePattern = regexp.MustCompile(`^[a-z0-9_-]{1,32}$`)
+
+// VariantPreset describes one named derivative, e.g. thumb 150x150 cover webp
+type VariantPreset struct {
+	Name    string `json:"name"`    // 1-32 characters of a-z, 0-9, _ and -
+	Width   int    `json:"width"`   // 0 keeps the aspect ratio, at most transform.max_dimension
+	Height  int    `json:"height"`  // 0 keeps the aspect ratio, at most transform.max_dimension
+	Fit     string `json:"fit"`     // contain (default), cover or fill
+	Format  string `json:"format"`  // jpeg, png or webp
+	Quality int    `json:"quality"` // jpeg/webp quality, defaults to 80
+}
+
+// ValidName reports whether the name can be stored with the image and used in the variant's object key
+func (p VariantPreset) ValidName() bool {
+	return variantNamePattern.MatchString(p.Name)
+}
+
+type AdminConfig struct {
+	Token string `json:"token"` // bearer token of the /admin API, the API is disabled when empty
+}
+
+// MetricsConfig configures the /metrics listener of worker processes.
+// The API serves /metrics on server.port.
+type MetricsConfig struct {
+	Port int `json:"port"` // disabled when 0
+}
+
+// TracingConfig configures the export of OpenTelemetry spans over OTLP/HTTP
+type TracingConfig struct {
+	Endpoint    string  `json:"endpoint"`     // collector host:port, e.g. localhost:4318, tracing is off when empty
+	Insecure    bool    `json:"insecure"`     // plain HTTP instead of HTTPS
+	SampleRatio float64 `json:"sample_ratio"` // share of new traces recorded, defaults to 1
+}
+
+// Log output formats selectable in LogConfig.Format
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+type LogConfig struct {
+	Level  string `json:"level"`  // debug, info (default), warn or error
+	Format string `json:"format"` // "text" (default) or "json" for log collectors
 }
 
 type SentryConfig struct {
@@ -0,0 +1,191 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"strings"
+)
+
+// Validate reports every missing or invalid value at once, so a broken deploy fails at startup
+// with the full list instead of on the first request that needs the value.
+// It covers everything the serve and worker commands use.
+func (c *Config) Validate() error {
+	var v validator
+	c.checkDatabase(&v)
+	c.checkServer(&v)
+	c.checkUpload(&v)
+	c.checkRedis(&v)
+	c.checkStorage(&v)
+	c.checkWebP(&v)
+	c.checkPurge(&v)
+	c.checkTransform(&v)
+	c.checkSigning(&v)
+	c.checkVariants(&v)
+	c.checkObservability(&v)
+	return v.err()
+}
+
+// ValidateMigrate checks what the migrate command uses, the database and logging
+func (c *Config) ValidateMigrate() error {
+	var v validator
+	c.checkDatabase(&v)
+	c.checkLog(&v)
+	return v.err()
+}
+
+// ValidateDLQ checks what the dlq command uses, redis, the job streams and logging
+func (c *Config) ValidateDLQ() error {
+	var v validator
+	c.checkRedis(&v)
+	c.checkWebP(&v)
+	c.checkLog(&v)
+	return v.err()
+}
+
+func (c *Config) checkDatabase(v *validator) {
+	v.check(c.Database.DSN != "", "database.dsn is required")
+}
+
+func (c *Config) checkServer(v *validator) {
+	v.check(c.Server.Port > 0 && c.Server.Port <= 65535, "server.port must be between 1 and 65535")
+	v.nonNegative("server.read_timeout", Duration(c.Server.ReadTimeout))
+	v.nonNegative("server.read_header_timeout", c.Server.ReadHeaderTimeout)
+	v.nonNegative("server.write_timeout", Duration(c.Server.WriteTimeout))
+	v.nonNegative("server.idle_timeout", c.Server.IdleTimeout)
+	v.nonNegative("server.shutdown_timeout", c.Server.ShutdownTimeout)
+}
+
+func (c *Config) checkUpload(v *validator) {
+	v.check(c.Upload.MaxRequestBodyMB > 0, "upload.max_request_body must be positive")
+	v.check(c.Upload.MaxMultipartMemoryMB > 0, "upload.max_multipart_memory must be positive")
+	for i, t := range c.Upload.AllowedMIMETypes {
+		v.check(slices.Contains(DecodableMIMETypes, t), "upload.allowed_mime_types[%d] %q can't be decoded, want one of %s",
+			i, t, strings.Join(DecodableMIMETypes, ", "))
+	}
+}
+
+func (c *Config) checkRedis(v *validator) {
+	v.check(len(c.Redis.Nodes) > 0, "redis.nodes needs at least one node")
+	for i, n := range c.Redis.Nodes {
+		v.check(n.Host != "", "redis.nodes[%d].host is required", i)
+		v.check(n.Port > 0 && n.Port <= 65535, "redis.nodes[%d].port must be between 1 and 65535", i)
+	}
+	v.check(c.Redis.HealthCheckInterval > 0, "redis.health_check_interval must be positive")
+	v.nonNegative("redis.dial_timeout", c.Redis.DialTimeout)
+	v.nonNegative("redis.read_timeout", c.Redis.ReadTimeout)
+	v.nonNegative("redis.write_timeout", c.Redis.WriteTimeout)
+}
+
+func (c *Config) checkStorage(v *validator) {
+	switch c.Storage.Backend {
+	case "", StorageBackendR2:
+		v.check(c.R2.BucketName != "", "r2.bucket_name is required")
+		v.check(c.R2.AccessKeyID != "", "r2.access_key_id is required")
+		v.check(c.R2.SecretKey != "", "r2.secret_key is required")
+		v.check(c.R2.Retries() >= 0, "r2.max_retries can't be negative")
+		v.nonNegative("r2.retry_base_delay", c.R2.RetryBaseDelay)
+		v.check(c.R2.AccountID != "" || c.R2.Endpoint != "" || c.R2.Region != "",
+			"r2 needs account_id (Cloudflare R2), endpoint (other S3 compatible stores) or region (AWS S3)")
+	case StorageBackendLocal:
+		v.check(c.Storage.LocalDir != "", "storage.local_dir is required by the local backend")
+	case StorageBackendMemory:
+	default:
+		v.add("storage.backend must be r2, local or memory, got %q", c.Storage.Backend)
+	}
+}
+
+func (c *Config) checkWebP(v *validator) {
+	v.check(c.WebP.Stream != "", "webp_worker.stream is required")
+	v.check(c.WebP.Group != "", "webp_worker.group is required")
+	v.check(c.WebP.Consumer != "", "webp_worker.consumer is required")
+	v.check(c.WebP.Workers > 0, "webp_worker.workers must be positive")
+	v.check(c.WebP.MaxAttempts > 0, "webp_worker.max_attempts must be positive")
+	v.check(c.WebP.DeadLetterMaxLen >= 0, "webp_worker.dead_letter_max_len can't be negative")
+	v.nonNegative("webp_worker.backoff_base", Duration(c.WebP.BackoffBase))
+	v.nonNegative("webp_worker.block_timeout", Duration(c.WebP.BlockTimeout))
+}
+
+func (c *Config) checkPurge(v *validator) {
+	v.nonNegative("purge.retention", c.Purge.Retention)
+	v.nonNegative("purge.interval", c.Purge.Interval)
+}
+
+func (c *Config) checkTransform(v *validator) {
+	v.nonNegative("transform.cache_ttl", c.Transform.CacheTTL)
+	v.check(c.Transform.DefaultQuality >= 0 && c.Transform.DefaultQuality <= 100, "transform.default_quality must be between 0 and 100")
+	// image widths and heights are SMALLINT columns
+	v.check(c.Transform.MaxDimension >= 0 && c.Transform.MaxDimension <= math.MaxInt16,
+		"transform.max_dimension must be between 0 and %d", math.MaxInt16)
+}
+
+func (c *Config) checkSigning(v *validator) {
+	v.nonNegative("signing.default_ttl", c.Signing.DefaultTTL)
+	v.check(c.Signing.DefaultTTL.Duration() <= MaxSignedURLTTL, "signing.default_ttl can't exceed %s", MaxSignedURLTTL)
+	keys := make(map[string]bool, len(c.Signing.Keys))
+	for i, k := range c.Signing.Keys {
+		v.check(k.ID != "", "signing.keys[%d].id is required", i)
+		v.check(k.Secret != "", "signing.keys[%d].secret is required", i)
+		v.check(!keys[k.ID], "signing.keys[%d].id %q is used twice", i, k.ID)
+		keys[k.ID] = true
+	}
+	v.check(c.Signing.ActiveKey == "" || keys[c.Signing.ActiveKey], "signing.active_key %q is not one of signing.keys", c.Signing.ActiveKey)
+	v.check(!c.Signing.RequireSigned() || len(c.Signing.Keys) > 0, "signing.required needs at least one signing key")
+}
+
+func (c *Config) checkVariants(v *validator) {
+	maxSize := min(c.Transform.MaxSize(), math.MaxInt16)
+	for _, project := range slices.Sorted(maps.Keys(c.Variants)) {
+		presets := c.Variants[project]
+		names := make(map[string]bool, len(presets))
+		for i, p := range presets {
+			field := fmt.Sprintf("variants[%q][%d]", project, i)
+			v.check(p.ValidName(), "%s.name %q must be 1-32 characters of a-z, 0-9, _ and -", field, p.Name)
+			v.check(!names[p.Name], "%s.name %q is used twice", field, p.Name)
+			names[p.Name] = true
+			v.check(p.Width >= 0 && p.Height >= 0, "%s width and height can't be negative", field)
+			v.check(p.Width <= maxSize && p.Height <= maxSize, "%s width and height can't exceed transform.max_dimension %d", field, maxSize)
+			v.check(slices.Contains([]string{"", "contain", "cover", "fill"}, p.Fit), "%s.fit must be contain, cover or fill", field)
+			v.check(slices.Contains([]string{"", "jpeg", "png", "webp"}, p.Format), "%s.format must be jpeg, png or webp", field)
+			v.check(p.Quality >= 0 && p.Quality <= 100, "%s.quality must be between 0 and 100", field)
+		}
+	}
+}
+
+func (c *Config) checkObservability(v *validator) {
+	c.checkLog(v)
+	v.check(c.Metrics.Port >= 0 && c.Metrics.Port <= 65535, "metrics.port must be between 0 and 65535")
+	v.check(c.Tracing.SampleRatio >= 0 && c.Tracing.SampleRatio <= 1, "tracing.sample_ratio must be between 0 and 1")
+}
+
+func (c *Config) checkLog(v *validator) {
+	v.check(slices.Contains([]string{"", "debug", "info", "warn", "error"}, strings.ToLower(c.Log.Level)), "log.level must be debug, info, warn or error")
+	v.check(slices.Contains([]string{"", LogFormatText, LogFormatJSON}, strings.ToLower(c.Log.Format)), "log.format must be text or json")
+}
+
+type validator struct {
+	errs []string
+}
+
+func (v *validator) check(ok bool, format string, args ...any) {
+	if !ok {
+		v.add(format, args...)
+	}
+}
+
+func (v *validator) nonNegative(name string, d Duration) {
+	v.check(d >= 0, "%s can't be negative", name)
+}
+
+func (v *validator) add(format string, args ...any) {
+	v.errs = append(v.errs, fmt.Sprintf(format, args...))
+}
+
+func (v *validator) err() error {
+	if len(v.errs) > 0 {
+		return errors.New("invalid config:\n  " + strings.Join(v.errs, "\n  "))
+	}
+	return nil
+}
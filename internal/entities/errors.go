@@ -0,0 +1,12 @@
+package entities
+
+import "errors"
+
+var (
+	ErrImageAlreadyExists = errors.New("image with this key already exists")
+	ErrImageNotFound      = errors.New("image not found")
+	ErrInvalidCursor      = errors.New("invalid pagination cursor")
+	ErrUploadFailed       = errors.New("failed to store image")
+	ErrLinkNotFound       = errors.New("link not found or expired")
+	ErrOrderMismatch      = errors.New("image_ids must list every image of the item exactly once")
+)
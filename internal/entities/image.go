@@ -2,22 +2,72 @@ package entities
 
 import "time"
 
+// Upload statuses of the original object, stored in images.upload_status
+const (
+	UploadPending = "pending"
+	UploadDone    = "done"
+	UploadFailed  = "failed"
+)
+
+// Conversion statuses of the WebP derivative, stored in images.conversion_status
+const (
+	ConversionPending = "pending"
+	ConversionDone    = "done"
+	ConversionFailed  = "failed"
+)
+
 type Image struct {
-	ID               int64     `json:"id"`
-	UserID           int64     `json:"user_id"`
-	ItemID           int64     `json:"item_id"`
-	SKU              *string   `json:"sku,omitempty"`
-	Context          string    `json:"context"`
-	Description      *string   `json:"description,omitempty"`
-	Width            int16     `json:"width"`
-	Height           int16     `json:"height"`
-	Project          string    `json:"project"`
-	Size             int32     `json:"size"`
-	Key              string    `json:"key"`
-	WebPKey          *string   `json:"webp_key,omitempty"`
-	MimeType         string    `json:"mime_type"`
-	IsDeleted        bool      `json:"is_deleted"`
-	OrderIndex       int16     `json:"order_index"`
-	CreatedTimestamp time.Time `json:"created_timestamp"`
-	UpdatedTimestamp time.Time `json:"updated_timestamp"`
+	ID               int64      `json:"id"`
+	UserID           int64      `json:"user_id"`
+	ItemID           int64      `json:"item_id"`
+	SKU              *string    `json:"sku,omitempty"`
+	Context          string     `json:"context"`
+	Description      *string    `json:"description,omitempty"`
+	Width            int16      `json:"width"`
+	Height           int16      `json:"height"`
+	Project          string     `json:"project"`
+	Size             int32      `json:"size"`
+	Key              string     `json:"key"`
+	UploadStatus     string     `json:"upload_status"`
+	WebPKey          *string    `json:"webp_key,omitempty"`
+	ConversionStatus string     `json:"conversion_status"`
+	MimeType         string     `json:"mime_type"`
+	IsDeleted        bool       `json:"is_deleted"`
+	OrderIndex       int16      `json:"order_index"`
+	CreatedTimestamp time.Time  `json:"created_timestamp"`
+	UpdatedTimestamp time.Time  `json:"updated_timestamp"`
+	DeletedTimestamp *time.Time `json:"deleted_timestamp,omitempty"`
+
+	Variants []ImageVariant `json:"variants,omitempty"`
+}
+
+// ImageVariant is a named derivative generated from the original after upload
+type ImageVariant struct {
+	Name     string `json:"name"`
+	Key      string `json:"key"`
+	Width    int16  `json:"width"`
+	Height   int16  `json:"height"`
+	Size     int32  `json:"size"`
+	MimeType string `json:"mime_type"`
+}
+
+// ImagePage is a single page of a cursor paginated image listing.
+// NextCursor is empty when there are no more images.
+type ImagePage struct {
+	Images     []Image `json:"images"`
+	NextCursor string  `json:"next_cursor,omitempty"`
+}
+
+// ImageLink is a temporary, optionally single-use link to an image
+type ImageLink struct {
+	Token     string    `json:"token"`
+	URL       string    `json:"url"`
+	SingleUse bool      `json:"single_use"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// SignedURL is a stateless, HMAC signed /img URL
+type SignedURL struct {
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expires_at"`
 }
@@ -0,0 +1,151 @@
+package keygen
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	// maxNameLen keeps the final key well below images.key VARCHAR(255)
+	maxNameLen = 100
+	// hashLen is the number of hex characters taken from the content digest
+	hashLen = 32
+)
+
+// ErrInvalidProject is returned by New for a project that is not a valid key segment, see ValidProject
+var ErrInvalidProject = errors.New("project must be 1-64 characters of a-z, 0-9, _ and -, starting with a letter or digit")
+
+// projectPattern keeps the project segment of two different projects distinct
+// and keeps originals out of the rendition roots, which start with "_"
+var projectPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+// ValidProject reports whether project can be used as the first segment of an object key
+func ValidProject(project string) bool {
+	return projectPattern.MatchString(project)
+}
+
+// Generator produces object keys of the form
+// <project>/<userID>/<itemID>/<name><ext>
+// where name is either a sanitised original filename or a content-derived hash.
+// Successive attempts yield different candidates so callers can retry on conflicts.
+type Generator struct {
+	prefix string
+	name   string
+	ext    string
+	hashed bool
+}
+
+// New prepares a generator for a single upload.
+// If filename is non-empty and survives sanitising it is used as the base name,
+// otherwise the name is derived from the payload digest.
+// The project is used as is, so it must satisfy ValidProject.
+func New(project string, userID, itemID int64, filename string, payload []byte, ext string) (*Generator, error) {
+	if !ValidProject(project) {
+		return nil, ErrInvalidProject
+	}
+	g := &Generator{
+		prefix: fmt.Sprintf("%s/%d/%d", project, userID, itemID),
+		ext:    strings.ToLower(ext),
+	}
+
+	if filename != "" {
+		g.name = SanitizeFilename(filename)
+	}
+	if g.name == "" {
+		sum := sha256.Sum256(payload)
+		g.name = hex.EncodeToString(sum[:])[:hashLen]
+		g.hashed = true
+	}
+
+	return g, nil
+}
+
+// Key returns the candidate key for the given attempt (starting at 0).
+// Attempt 0 is fully deterministic; later attempts add a numeric suffix for
+// preserved filenames or a random suffix for content-derived names.
+func (g *Generator) Key(attempt int) (string, error) {
+	name := g.name
+	if attempt > 0 {
+		if g.hashed {
+			suffix, err := RandomSuffix(4)
+			if err != nil {
+				return "", err
+			}
+			name += "-" + suffix
+		} else {
+			name += "-" + strconv.Itoa(attempt)
+		}
+	}
+	return g.prefix + "/" + name + g.ext, nil
+}
+
+const (
+	derivedRoot = "_derived/"
+	variantRoot = "_variants/"
+)
+
+// DerivedPrefix returns the prefix under which renditions of the original key are stored
+func DerivedPrefix(key string) string {
+	return derivedRoot + key + "/"
+}
+
+// VariantPrefix returns the prefix under which the named variants of the original key are stored
+func VariantPrefix(key string) string {
+	return variantRoot + key + "/"
+}
+
+// IsRendition reports whether key is a rendition or variant of some original rather than an original
+func IsRendition(key string) bool {
+	return strings.HasPrefix(key, derivedRoot) || strings.HasPrefix(key, variantRoot)
+}
+
+// VariantKey returns the predictable key of a named variant
+func VariantKey(key string, name string, format string) string {
+	return VariantPrefix(key) + name + "." + format
+}
+
+// RandomSuffix returns n random bytes encoded as hex
+func RandomSuffix(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate random suffix: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// SanitizeFilename strips any directory and extension from the client supplied
+// filename and reduces it to lowercase [a-z0-9_-] so it is safe to use in URLs and object keys.
+func SanitizeFilename(filename string) string {
+	base := path.Base(strings.ReplaceAll(filename, "\\", "/"))
+	base = strings.TrimSuffix(base, path.Ext(base))
+	return sanitizeSegment(base)
+}
+
+func sanitizeSegment(s string) string {
+	var b strings.Builder
+	lastDash := false
+
+	for _, r := range strings.ToLower(s) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '_':
+			b.WriteRune(r)
+			lastDash = false
+		case !lastDash:
+			b.WriteRune('-')
+			lastDash = true
+		}
+	}
+
+	out := strings.Trim(b.String(), "-")
+	if len(out) > maxNameLen {
+		out = strings.TrimRight(out[:maxNameLen], "-")
+	}
+	return out
+}
@@ -0,0 +1,125 @@
+package keygen
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestSanitizeFilename(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"photo.png", "photo"},
+		{"My Photo (1).JPG", "my-photo-1"},
+		{"dir/sub/photo.webp", "photo"},
+		{`C:\Users\me\photo.jpeg`, "photo"},
+		{"../../etc/passwd", "passwd"},
+		{"snake_case-name.png", "snake_case-name"},
+		{"--a--b--.png", "a-b"},
+		{"archive.tar.gz", "archive-tar"},
+		{"фото.png", ""},
+		{".png", ""},
+		{"", ""},
+		{strings.Repeat("a", 150) + ".png", strings.Repeat("a", maxNameLen)},
+		{strings.Repeat("a", maxNameLen-1) + " b.png", strings.Repeat("a", maxNameLen-1)},
+	}
+
+	for _, tt := range tests {
+		if got := SanitizeFilename(tt.in); got != tt.want {
+			t.Errorf("SanitizeFilename(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestValidProject(t *testing.T) {
+	tests := []struct {
+		project string
+		want    bool
+	}{
+		{"shop", true},
+		{"shop-2_eu", true},
+		{"0", true},
+		{strings.Repeat("a", 64), true},
+		{strings.Repeat("a", 65), false},
+		{"", false},
+		{"Shop", false},
+		{"sh op", false},
+		{"shop/1", false},
+		{"..", false},
+		{"-shop", false},
+		{"_derived", false},
+		{"_variants", false},
+	}
+
+	for _, tt := range tests {
+		if got := ValidProject(tt.project); got != tt.want {
+			t.Errorf("ValidProject(%q) = %v, want %v", tt.project, got, tt.want)
+		}
+	}
+}
+
+func TestKey(t *testing.T) {
+	payload := []byte("payload")
+	const digest = "239f59ed55e737c77147cf55ad0c1b03" // first 32 hex characters of sha256("payload")
+
+	tests := []struct {
+		name     string
+		project  string
+		filename string
+		ext      string
+		attempt  int
+		want     string // a regexp for attempts with a random suffix
+	}{
+		{name: "preserved filename", project: "shop", filename: "My Photo.PNG", ext: ".PNG", want: "shop/7/42/my-photo.png"},
+		{name: "preserved filename, retry", project: "shop", filename: "photo.png", ext: ".png", attempt: 2, want: "shop/7/42/photo-2.png"},
+		{name: "content hash", project: "shop", ext: ".webp", want: "shop/7/42/" + digest + ".webp"},
+		{name: "unusable filename falls back to the hash", project: "shop", filename: "фото.jpg", ext: ".jpg", want: "shop/7/42/" + digest + ".jpg"},
+		{name: "content hash, retry", project: "shop", ext: ".webp", attempt: 1, want: `^shop/7/42/` + digest + `-[0-9a-f]{8}\.webp$`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g, err := New(tt.project, 7, 42, tt.filename, payload, tt.ext)
+			if err != nil {
+				t.Fatalf("New: %v", err)
+			}
+			got, err := g.Key(tt.attempt)
+			if err != nil {
+				t.Fatalf("Key: %v", err)
+			}
+			if strings.HasPrefix(tt.want, "^") {
+				if !regexp.MustCompile(tt.want).MatchString(got) {
+					t.Errorf("Key(%d) = %q, want a match of %s", tt.attempt, got, tt.want)
+				}
+			} else if got != tt.want {
+				t.Errorf("Key(%d) = %q, want %q", tt.attempt, got, tt.want)
+			}
+			if IsRendition(got) {
+				t.Errorf("IsRendition(%q) = true for an original", got)
+			}
+		})
+	}
+}
+
+func TestNewRejectsInvalidProjects(t *testing.T) {
+	for _, project := range []string{"Shop", "sh op", "_derived", "a/b", ""} {
+		if _, err := New(project, 1, 1, "a.png", nil, ".png"); !errors.Is(err, ErrInvalidProject) {
+			t.Errorf("New(%q) error = %v, want ErrInvalidProject", project, err)
+		}
+	}
+}
+
+func TestRenditionKeys(t *testing.T) {
+	const key = "shop/7/42/photo.png"
+	for _, k := range []string{DerivedPrefix(key) + "w100.webp", VariantKey(key, "thumb", "webp")} {
+		if !IsRendition(k) {
+			t.Errorf("IsRendition(%q) = false, want true", k)
+		}
+	}
+	if got := VariantKey(key, "thumb", "webp"); got != "_variants/shop/7/42/photo.png/thumb.webp" {
+		t.Errorf("VariantKey = %q", got)
+	}
+}
@@ -0,0 +1,166 @@
+// Package metrics defines the Prometheus metrics of the service and the /metrics handler
+package metrics
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "mediahub"
+
+// scrapeTimeout bounds the redis calls made while collecting stream stats
+const scrapeTimeout = 2 * time.Second
+
+var (
+	uploadRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "upload_requests_total",
+		Help:      "Upload requests by project, detected MIME type and response status.",
+	}, []string{"project", "mime_type", "status"})
+
+	uploadDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "upload_duration_seconds",
+		Help:      "Time to handle an upload request.",
+		Buckets:   []float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+	}, []string{"project", "mime_type"})
+
+	uploadSize = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "upload_size_bytes",
+		Help:      "Size of uploaded files.",
+		Buckets:   prometheus.ExponentialBuckets(16<<10, 4, 8), // 16KiB .. 256MiB
+	}, []string{"project", "mime_type"})
+
+	// R2UploadRetries counts retried R2 upload attempts
+	R2UploadRetries = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "r2_upload_retries_total",
+		Help:      "R2 upload attempts that failed and were retried.",
+	})
+
+	// R2UploadFailures counts R2 uploads that failed after all retries
+	R2UploadFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "r2_upload_failures_total",
+		Help:      "R2 uploads that failed after all retries.",
+	})
+
+	jobDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "job_duration_seconds",
+		Help:      "Time to run a queue job (WebP conversion, variants) by job type.",
+		Buckets:   []float64{.1, .25, .5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"type"})
+
+	jobFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "job_failures_total",
+		Help:      "Failed queue job attempts by job type and outcome (retry or dead_letter).",
+	}, []string{"type", "outcome"})
+
+	// RedisReconnects counts clients rebuilt by the redis health loop, by result
+	RedisReconnects = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "redis_reconnects_total",
+		Help:      "Redis reconnects made by the health loop after a failed ping, by result.",
+	}, []string{"result"})
+)
+
+// Handler serves the metrics in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// ObserveUpload records one upload request. Unknown project or type are reported as "unknown".
+func ObserveUpload(project, mimeType string, status int, size int64, elapsed time.Duration) {
+	project, mimeType = orUnknown(project), orUnknown(mimeType)
+
+	uploadRequests.WithLabelValues(project, mimeType, strconv.Itoa(status)).Inc()
+	uploadDuration.WithLabelValues(project, mimeType).Observe(elapsed.Seconds())
+	if size > 0 {
+		uploadSize.WithLabelValues(project, mimeType).Observe(float64(size))
+	}
+}
+
+// ObserveJob records the run time of one queue job
+func ObserveJob(jobType string, elapsed time.Duration) {
+	jobDuration.WithLabelValues(orUnknown(jobType)).Observe(elapsed.Seconds())
+}
+
+// JobFailed records a failed job attempt, deadLetter tells whether it was given up
+func JobFailed(jobType string, deadLetter bool) {
+	outcome := "retry"
+	if deadLetter {
+		outcome = "dead_letter"
+	}
+	jobFailures.WithLabelValues(orUnknown(jobType), outcome).Inc()
+}
+
+func orUnknown(s string) string {
+	if s == "" {
+		return "unknown"
+	}
+	return s
+}
+
+// StreamStats is the state of a job stream and its consumer group
+type StreamStats struct {
+	Length      int64 // entries in the stream
+	Pending     int64 // delivered but not yet acknowledged
+	Lag         int64 // not yet delivered to the group, -1 when redis can't tell
+	Delayed     int64 // waiting for a retry
+	DeadLetters int64 // failed for good
+}
+
+// RegisterStream exports the stats of a stream, read from redis on every scrape
+func RegisterStream(stream, group string, stats func(ctx context.Context) (StreamStats, error), logger *slog.Logger) {
+	prometheus.MustRegister(&streamCollector{stream: stream, group: group, stats: stats, log: logger})
+}
+
+var (
+	streamLengthDesc  = prometheus.NewDesc(namespace+"_stream_length", "Entries in the job stream.", []string{"stream"}, nil)
+	streamPendingDesc = prometheus.NewDesc(namespace+"_stream_pending", "Jobs delivered to the consumer group but not acknowledged.", []string{"stream", "group"}, nil)
+	streamLagDesc     = prometheus.NewDesc(namespace+"_stream_lag", "Jobs not yet delivered to the consumer group.", []string{"stream", "group"}, nil)
+	streamDelayedDesc = prometheus.NewDesc(namespace+"_stream_delayed", "Jobs waiting for a retry.", []string{"stream"}, nil)
+	streamDLQDesc     = prometheus.NewDesc(namespace+"_stream_dead_letters", "Jobs in the dead-letter stream.", []string{"stream"}, nil)
+)
+
+type streamCollector struct {
+	stream, group string
+	stats         func(ctx context.Context) (StreamStats, error)
+	log           *slog.Logger
+}
+
+func (c *streamCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- streamLengthDesc
+	ch <- streamPendingDesc
+	ch <- streamLagDesc
+	ch <- streamDelayedDesc
+	ch <- streamDLQDesc
+}
+
+func (c *streamCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), scrapeTimeout)
+	defer cancel()
+
+	s, err := c.stats(ctx)
+	if err != nil {
+		c.log.Error("metrics: read stream stats", "stream", c.stream, "error", err)
+		ch <- prometheus.NewInvalidMetric(streamLengthDesc, err)
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(streamLengthDesc, prometheus.GaugeValue, float64(s.Length), c.stream)
+	ch <- prometheus.MustNewConstMetric(streamPendingDesc, prometheus.GaugeValue, float64(s.Pending), c.stream, c.group)
+	ch <- prometheus.MustNewConstMetric(streamLagDesc, prometheus.GaugeValue, float64(s.Lag), c.stream, c.group)
+	ch <- prometheus.MustNewConstMetric(streamDelayedDesc, prometheus.GaugeValue, float64(s.Delayed), c.stream)
+	ch <- prometheus.MustNewConstMetric(streamDLQDesc, prometheus.GaugeValue, float64(s.DeadLetters), c.stream)
+}
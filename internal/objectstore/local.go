@@ -0,0 +1,208 @@
+package objectstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var _ ObjectStore = (*Local)(nil)
+
+// metaDir holds the content type of each object below Root, at the object's key
+const metaDir = ".meta"
+
+// Local stores objects as plain files below Root, using the key as relative path.
+// The content type given to Put is kept in a file below Root/.meta; for files without one,
+// e.g. copied into Root by hand, it is derived from the key extension, falling back to sniffing the payload.
+type Local struct {
+	Root string
+}
+
+func NewLocal(root string) (*Local, error) {
+	if root == "" {
+		return nil, errors.New("local object store: root directory is not set")
+	}
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return nil, fmt.Errorf("local object store: %w", err)
+	}
+	return &Local{Root: root}, nil
+}
+
+func (l *Local) Put(ctx context.Context, key string, contentType string, payload []byte) error {
+	p, err := l.path(key)
+	if err != nil {
+		return err
+	}
+
+	// the content type goes first, so a reader of the new payload never sees the type of the old one
+	if err := writeFile(l.metaPath(p), []byte(contentType)); err != nil {
+		return fmt.Errorf("put %q: %w", key, err)
+	}
+	if err := writeFile(p, payload); err != nil {
+		return fmt.Errorf("put %q: %w", key, err)
+	}
+	return nil
+}
+
+// writeFile writes to a temp file first so readers never observe a partial file
+func writeFile(p string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), p)
+}
+
+func (l *Local) Get(ctx context.Context, key string) ([]byte, string, error) {
+	p, err := l.path(key)
+	if err != nil {
+		return nil, "", err
+	}
+
+	payload, err := os.ReadFile(p)
+	if err != nil {
+		return nil, "", fmt.Errorf("get %q: %w", key, mapNotExist(err))
+	}
+	return payload, l.contentType(p, key, payload), nil
+}
+
+func (l *Local) Head(ctx context.Context, key string) (Object, error) {
+	p, err := l.path(key)
+	if err != nil {
+		return Object{}, err
+	}
+
+	info, err := os.Stat(p)
+	if err != nil {
+		return Object{}, fmt.Errorf("head %q: %w", key, mapNotExist(err))
+	}
+	return l.describe(p, key, info), nil
+}
+
+func (l *Local) Delete(ctx context.Context, keys ...string) error {
+	for _, key := range keys {
+		p, err := l.path(key)
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("delete %q: %w", key, err)
+		}
+		if err := os.Remove(l.metaPath(p)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("delete %q: %w", key, err)
+		}
+	}
+	return nil
+}
+
+func (l *Local) List(ctx context.Context, prefix string) ([]Object, error) {
+	var out []Object
+
+	err := filepath.WalkDir(l.Root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && p == filepath.Join(l.Root, metaDir) {
+			return filepath.SkipDir
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".upload-") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(l.Root, p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		out = append(out, l.describe(p, key, info))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list %q: %w", prefix, err)
+	}
+
+	sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+	return out, nil
+}
+
+func (l *Local) Copy(ctx context.Context, srcKey string, dstKey string) error {
+	payload, contentType, err := l.Get(ctx, srcKey)
+	if err != nil {
+		return fmt.Errorf("copy: %w", err)
+	}
+	return l.Put(ctx, dstKey, contentType, payload)
+}
+
+// path maps a key to a file below Root, rejecting keys that would escape it or land in metaDir
+func (l *Local) path(key string) (string, error) {
+	clean := path.Clean("/" + key)
+	if key == "" || clean == "/" || clean == "/"+metaDir || strings.HasPrefix(clean, "/"+metaDir+"/") {
+		return "", fmt.Errorf("invalid object key %q", key)
+	}
+	return filepath.Join(l.Root, filepath.FromSlash(clean)), nil
+}
+
+// metaPath returns the file holding the content type of the object file p
+func (l *Local) metaPath(p string) string {
+	rel, _ := filepath.Rel(l.Root, p)
+	return filepath.Join(l.Root, metaDir, rel)
+}
+
+func (l *Local) describe(p string, key string, info fs.FileInfo) Object {
+	return Object{
+		Key:          key,
+		Size:         info.Size(),
+		ContentType:  l.contentType(p, key, nil),
+		LastModified: info.ModTime(),
+	}
+}
+
+// contentType returns the stored content type of the object file p, or guesses it from the key and payload
+func (l *Local) contentType(p string, key string, payload []byte) string {
+	if ct, err := os.ReadFile(l.metaPath(p)); err == nil && len(ct) > 0 {
+		return string(ct)
+	}
+	if ct := mime.TypeByExtension(path.Ext(key)); ct != "" {
+		return ct
+	}
+	if payload == nil {
+		return ""
+	}
+	return http.DetectContentType(payload)
+}
+
+func mapNotExist(err error) error {
+	if errors.Is(err, fs.ErrNotExist) {
+		return ErrNotFound
+	}
+	return err
+}
@@ -0,0 +1,108 @@
+package objectstore
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var _ ObjectStore = (*Memory)(nil)
+
+type memoryObject struct {
+	payload      []byte
+	contentType  string
+	lastModified time.Time
+}
+
+// Memory keeps objects in process memory. Intended for tests and local experiments.
+type Memory struct {
+	mu      sync.RWMutex
+	objects map[string]memoryObject
+}
+
+func NewMemory() *Memory {
+	return &Memory{objects: make(map[string]memoryObject)}
+}
+
+func (m *Memory) Put(ctx context.Context, key string, contentType string, payload []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.objects[key] = memoryObject{
+		payload:      append([]byte(nil), payload...),
+		contentType:  contentType,
+		lastModified: time.Now(),
+	}
+	return nil
+}
+
+func (m *Memory) Get(ctx context.Context, key string) ([]byte, string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	obj, ok := m.objects[key]
+	if !ok {
+		return nil, "", fmt.Errorf("get %q: %w", key, ErrNotFound)
+	}
+	return append([]byte(nil), obj.payload...), obj.contentType, nil
+}
+
+func (m *Memory) Head(ctx context.Context, key string) (Object, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	obj, ok := m.objects[key]
+	if !ok {
+		return Object{}, fmt.Errorf("head %q: %w", key, ErrNotFound)
+	}
+	return obj.describe(key), nil
+}
+
+func (m *Memory) Delete(ctx context.Context, keys ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, key := range keys {
+		delete(m.objects, key)
+	}
+	return nil
+}
+
+func (m *Memory) List(ctx context.Context, prefix string) ([]Object, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var out []Object
+	for key, obj := range m.objects {
+		if strings.HasPrefix(key, prefix) {
+			out = append(out, obj.describe(key))
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+	return out, nil
+}
+
+func (m *Memory) Copy(ctx context.Context, srcKey string, dstKey string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	obj, ok := m.objects[srcKey]
+	if !ok {
+		return fmt.Errorf("copy %q: %w", srcKey, ErrNotFound)
+	}
+	obj.lastModified = time.Now()
+	m.objects[dstKey] = obj // payload is never mutated in place, sharing it is safe
+	return nil
+}
+
+func (o memoryObject) describe(key string) Object {
+	return Object{
+		Key:          key,
+		Size:         int64(len(o.payload)),
+		ContentType:  o.contentType,
+		LastModified: o.lastModified,
+	}
+}
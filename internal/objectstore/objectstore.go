@@ -0,0 +1,28 @@
+package objectstore
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var ErrNotFound = errors.New("object not found")
+
+// Object describes a stored object without its payload
+type Object struct {
+	Key          string
+	Size         int64
+	ContentType  string
+	LastModified time.Time
+}
+
+// ObjectStore is implemented by every storage backend (R2/S3, local filesystem, memory).
+// Get and Head return ErrNotFound for missing keys; Delete ignores them.
+type ObjectStore interface {
+	Put(ctx context.Context, key string, contentType string, payload []byte) error
+	Get(ctx context.Context, key string) ([]byte, string, error)
+	Head(ctx context.Context, key string) (Object, error)
+	Delete(ctx context.Context, keys ...string) error
+	List(ctx context.Context, prefix string) ([]Object, error)
+	Copy(ctx context.Context, srcKey string, dstKey string) error
+}
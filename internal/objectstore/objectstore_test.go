@@ -0,0 +1,222 @@
+package objectstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testObjectStore checks the behaviour every backend shares with R2
+func testObjectStore(t *testing.T, newStore func(t *testing.T) ObjectStore) {
+	ctx := context.Background()
+
+	t.Run("put get head", func(t *testing.T) {
+		s := newStore(t)
+		payload := []byte("RIFF....WEBPVP8 ")
+		if err := s.Put(ctx, "p/1/a.webp", "image/webp", payload); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+
+		got, ct, err := s.Get(ctx, "p/1/a.webp")
+		if err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+		if !bytes.Equal(got, payload) || ct != "image/webp" {
+			t.Errorf("Get = %q, %q, want %q, image/webp", got, ct, payload)
+		}
+
+		obj, err := s.Head(ctx, "p/1/a.webp")
+		if err != nil {
+			t.Fatalf("Head: %v", err)
+		}
+		if obj.Key != "p/1/a.webp" || obj.Size != int64(len(payload)) || obj.ContentType != "image/webp" {
+			t.Errorf("Head = %+v", obj)
+		}
+		if obj.LastModified.IsZero() {
+			t.Error("Head: LastModified is zero")
+		}
+	})
+
+	t.Run("content type is kept as given", func(t *testing.T) {
+		s := newStore(t)
+		// the extension and the payload suggest other types, R2 returns what was put
+		if err := s.Put(ctx, "p/1/a.png", "image/jpeg", []byte("\x89PNG\r\n\x1a\n")); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+		if _, ct, err := s.Get(ctx, "p/1/a.png"); err != nil || ct != "image/jpeg" {
+			t.Errorf("Get content type = %q, %v, want image/jpeg", ct, err)
+		}
+		if obj, err := s.Head(ctx, "p/1/a.png"); err != nil || obj.ContentType != "image/jpeg" {
+			t.Errorf("Head content type = %q, %v, want image/jpeg", obj.ContentType, err)
+		}
+	})
+
+	t.Run("overwrite", func(t *testing.T) {
+		s := newStore(t)
+		if err := s.Put(ctx, "k", "image/png", []byte("old")); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+		if err := s.Put(ctx, "k", "image/webp", []byte("new!")); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+		got, ct, err := s.Get(ctx, "k")
+		if err != nil || string(got) != "new!" || ct != "image/webp" {
+			t.Errorf("Get = %q, %q, %v, want new!, image/webp", got, ct, err)
+		}
+	})
+
+	t.Run("missing keys", func(t *testing.T) {
+		s := newStore(t)
+		if _, _, err := s.Get(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Get: err = %v, want ErrNotFound", err)
+		}
+		if _, err := s.Head(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Head: err = %v, want ErrNotFound", err)
+		}
+		if err := s.Copy(ctx, "missing", "dst"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Copy: err = %v, want ErrNotFound", err)
+		}
+		if err := s.Delete(ctx, "missing"); err != nil {
+			t.Errorf("Delete: %v", err)
+		}
+	})
+
+	t.Run("copy", func(t *testing.T) {
+		s := newStore(t)
+		if err := s.Put(ctx, "src", "image/png", []byte("data")); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+		if err := s.Copy(ctx, "src", "dir/dst"); err != nil {
+			t.Fatalf("Copy: %v", err)
+		}
+		got, ct, err := s.Get(ctx, "dir/dst")
+		if err != nil || string(got) != "data" || ct != "image/png" {
+			t.Errorf("Get copy = %q, %q, %v, want data, image/png", got, ct, err)
+		}
+		if _, _, err := s.Get(ctx, "src"); err != nil {
+			t.Errorf("Get source after copy: %v", err)
+		}
+	})
+
+	t.Run("list and delete", func(t *testing.T) {
+		s := newStore(t)
+		for _, key := range []string{"p/1/b.png", "p/1/a.png", "p/10/c.png", "q/d.png"} {
+			if err := s.Put(ctx, key, "image/png", []byte(key)); err != nil {
+				t.Fatalf("Put %s: %v", key, err)
+			}
+		}
+
+		objects, err := s.List(ctx, "p/1")
+		if err != nil {
+			t.Fatalf("List: %v", err)
+		}
+		want := []string{"p/1/a.png", "p/1/b.png", "p/10/c.png"}
+		if len(objects) != len(want) {
+			t.Fatalf("List = %+v, want keys %v", objects, want)
+		}
+		for i, obj := range objects {
+			if obj.Key != want[i] || obj.Size != int64(len(want[i])) {
+				t.Errorf("List[%d] = %+v, want key %s", i, obj, want[i])
+			}
+		}
+
+		if err := s.Delete(ctx, "p/1/a.png", "p/1/b.png"); err != nil {
+			t.Fatalf("Delete: %v", err)
+		}
+		if _, _, err := s.Get(ctx, "p/1/a.png"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Get deleted: err = %v, want ErrNotFound", err)
+		}
+		if objects, err := s.List(ctx, "p/"); err != nil || len(objects) != 1 || objects[0].Key != "p/10/c.png" {
+			t.Errorf("List after delete = %+v, %v, want only p/10/c.png", objects, err)
+		}
+	})
+}
+
+func TestMemory(t *testing.T) {
+	testObjectStore(t, func(t *testing.T) ObjectStore {
+		return NewMemory()
+	})
+}
+
+func TestLocal(t *testing.T) {
+	testObjectStore(t, func(t *testing.T) ObjectStore {
+		l, err := NewLocal(t.TempDir())
+		if err != nil {
+			t.Fatalf("NewLocal: %v", err)
+		}
+		return l
+	})
+}
+
+func TestLocalContentTypeSurvivesRestart(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+
+	l, err := NewLocal(root)
+	if err != nil {
+		t.Fatalf("NewLocal: %v", err)
+	}
+	if err := l.Put(ctx, "a/b", "image/webp", []byte("data")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	reopened, err := NewLocal(root)
+	if err != nil {
+		t.Fatalf("NewLocal: %v", err)
+	}
+	if obj, err := reopened.Head(ctx, "a/b"); err != nil || obj.ContentType != "image/webp" {
+		t.Errorf("Head = %+v, %v, want content type image/webp", obj, err)
+	}
+}
+
+func TestLocalWithoutStoredContentType(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	l, err := NewLocal(root)
+	if err != nil {
+		t.Fatalf("NewLocal: %v", err)
+	}
+
+	// a file copied into the root by hand has no stored type, it is derived from the extension
+	if err := os.WriteFile(filepath.Join(root, "manual.png"), []byte("data"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, ct, err := l.Get(ctx, "manual.png"); err != nil || ct != "image/png" {
+		t.Errorf("Get = %q, %v, want image/png", ct, err)
+	}
+}
+
+func TestLocalRejectsKeysOutsideRoot(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	l, err := NewLocal(filepath.Join(root, "objects"))
+	if err != nil {
+		t.Fatalf("NewLocal: %v", err)
+	}
+
+	for _, key := range []string{"", "/", metaDir + "/a", metaDir} {
+		if err := l.Put(ctx, key, "image/png", []byte("x")); err == nil {
+			t.Errorf("Put(%q) succeeded, want an error", key)
+		}
+	}
+
+	// ".." can't climb above the root, the key stays inside it
+	if err := l.Put(ctx, "../escape", "image/png", []byte("x")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "escape")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("object was written outside the root: %v", err)
+	}
+
+	// content type files never show up as objects
+	objects, err := l.List(ctx, "")
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(objects) != 1 || objects[0].Key != "escape" {
+		t.Errorf("List = %+v, want only escape", objects)
+	}
+}
@@ -0,0 +1,74 @@
+package processor
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+
+	"github.com/chai2010/webp"
+	"github.com/trunov/mediahub/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Output formats supported by Encode
+const (
+	FormatJPEG = "jpeg"
+	FormatPNG  = "png"
+	FormatWEBP = "webp"
+)
+
+// Encode writes img in the given format.
+// quality (1-100) applies to jpeg and webp and is ignored for png.
+func Encode(ctx context.Context, img image.Image, format string, quality int) (_ []byte, err error) {
+	_, span := tracer.Start(ctx, "processor.Encode", trace.WithAttributes(
+		attribute.String("image.format", format),
+		attribute.Int("image.quality", quality),
+	))
+	defer func() { tracing.End(span, err) }()
+
+	buf := new(bytes.Buffer)
+
+	switch format {
+	case FormatJPEG:
+		err = jpeg.Encode(buf, img, &jpeg.Options{Quality: quality})
+	case FormatPNG:
+		err = png.Encode(buf, img)
+	case FormatWEBP:
+		err = webp.Encode(buf, img, &webp.Options{Quality: float32(quality)})
+	default:
+		err = fmt.Errorf("unsupported output format: %s", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// ContentType returns the MIME type produced by Encode for the format
+func ContentType(format string) string {
+	switch format {
+	case FormatPNG:
+		return "image/png"
+	case FormatWEBP:
+		return "image/webp"
+	default:
+		return "image/jpeg"
+	}
+}
+
+// FormatFromContentType maps a MIME type to the matching Encode format, defaulting to jpeg
+func FormatFromContentType(contentType string) string {
+	switch contentType {
+	case "image/png":
+		return FormatPNG
+	case "image/webp":
+		return FormatWEBP
+	default:
+		return FormatJPEG
+	}
+}
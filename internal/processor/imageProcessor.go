@@ -2,6 +2,7 @@ package processor
 
 import (
 	"bytes"
+	"context"
 	"image"
 	"image/jpeg"
 	"image/png"
@@ -9,17 +10,30 @@ import (
 
 	"github.com/chai2010/webp"
 	"github.com/disintegration/imaging"
+	"github.com/trunov/mediahub/internal/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
 )
 
+var tracer = otel.Tracer("github.com/trunov/mediahub/internal/processor")
+
 // ImageModifier defines an image modifier
 type ImageModifier interface {
 	Modify(img image.Image) image.Image
 }
 
+// Fit modes supported by ImageResizer
+const (
+	FitContain = "contain" // scale down to fit within Width x Height keeping the aspect ratio (default)
+	FitCover   = "cover"   // scale and crop from the center to exactly Width x Height
+	FitFill    = "fill"    // stretch to exactly Width x Height
+)
+
 // ImageResizer defines image resizer
 type ImageResizer struct {
 	Width  int
 	Height int
+	Fit    string
 }
 
 // Modify to implement ImageModifier interface
@@ -31,9 +45,25 @@ func (r *ImageResizer) Modify(img image.Image) image.Image {
 		return img
 	}
 
-	ratio := w / float64(r.Width)
-	if hRatio := h / float64(r.Height); hRatio > ratio {
-		ratio = hRatio
+	// cover and fill need both dimensions, otherwise they behave like contain
+	if r.Width > 0 && r.Height > 0 {
+		switch r.Fit {
+		case FitCover:
+			return imaging.Fill(img, r.Width, r.Height, imaging.Center, imaging.Lanczos)
+		case FitFill:
+			return imaging.Resize(img, r.Width, r.Height, imaging.Lanczos)
+		}
+	}
+
+	// A zero dimension is unconstrained
+	ratio := 0.0
+	if r.Width > 0 {
+		ratio = w / float64(r.Width)
+	}
+	if r.Height > 0 {
+		if hRatio := h / float64(r.Height); hRatio > ratio {
+			ratio = hRatio
+		}
 	}
 
 	// Nothing to do - return original image
@@ -45,11 +75,19 @@ func (r *ImageResizer) Modify(img image.Image) image.Image {
 }
 
 // LoadImage reads image from reader and applies requested modifiers to that image
-func LoadImage(r io.Reader, modifiers ...ImageModifier) (image.Image, error) {
-	img, _, err := image.Decode(r)
+func LoadImage(ctx context.Context, r io.Reader, modifiers ...ImageModifier) (_ image.Image, err error) {
+	_, span := tracer.Start(ctx, "processor.LoadImage")
+	defer func() { tracing.End(span, err) }()
+
+	img, format, err := image.Decode(r)
 	if err != nil {
 		return nil, err
 	}
+	span.SetAttributes(
+		attribute.String("image.format", format),
+		attribute.Int("image.width", img.Bounds().Dx()),
+		attribute.Int("image.height", img.Bounds().Dy()),
+	)
 
 	for _, modifier := range modifiers {
 		img = modifier.Modify(img)
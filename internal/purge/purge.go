@@ -0,0 +1,120 @@
+package purge
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/trunov/mediahub/internal/config"
+	"github.com/trunov/mediahub/internal/entities"
+	"github.com/trunov/mediahub/internal/keygen"
+	"github.com/trunov/mediahub/internal/objectstore"
+)
+
+const (
+	defaultRetention = 30 * 24 * time.Hour
+	defaultInterval  = time.Hour
+	defaultBatchSize = 100
+)
+
+type Storage interface {
+	PurgeDeletedImages(ctx context.Context, before time.Time, limit int, purge func(img entities.Image) error) (int, error)
+}
+
+type ObjectStorage interface {
+	Delete(ctx context.Context, keys ...string) error
+	List(ctx context.Context, prefix string) ([]objectstore.Object, error)
+}
+
+// Purger permanently removes soft-deleted images once their retention period has passed:
+// the rows are claimed first, then the original, WebP, variant and derived objects are deleted, then the rows.
+// Objects of a claimed image whose deletion failed are retried by a later run.
+type Purger struct {
+	storage Storage
+	objects ObjectStorage
+	cfg     config.PurgeConfig
+	log     *slog.Logger
+}
+
+func New(storage Storage, objects ObjectStorage, cfg config.PurgeConfig, logger *slog.Logger) *Purger {
+	if cfg.Retention <= 0 {
+		cfg.Retention = config.Duration(defaultRetention)
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = config.Duration(defaultInterval)
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = defaultBatchSize
+	}
+
+	return &Purger{
+		storage: storage,
+		objects: objects,
+		cfg:     cfg,
+		log:     logger.With("component", "purge"),
+	}
+}
+
+// Run purges expired images every Interval until ctx is canceled
+func (p *Purger) Run(ctx context.Context) {
+	p.log.InfoContext(ctx, "started", "retention", p.cfg.Retention, "interval", p.cfg.Interval)
+
+	t := time.NewTicker(p.cfg.Interval.Duration())
+	defer t.Stop()
+
+	for {
+		p.PurgeExpired(ctx)
+
+		select {
+		case <-ctx.Done():
+			p.log.Info("stopped", "reason", ctx.Err())
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// PurgeExpired removes expired images batch by batch until a batch comes back short
+func (p *Purger) PurgeExpired(ctx context.Context) {
+	before := time.Now().Add(-p.cfg.Retention.Duration())
+
+	for ctx.Err() == nil {
+		purged, err := p.storage.PurgeDeletedImages(ctx, before, p.cfg.BatchSize, func(img entities.Image) error {
+			return p.deleteObjects(ctx, img)
+		})
+		if err != nil {
+			p.log.ErrorContext(ctx, "batch failed", "error", err)
+			return
+		}
+		if purged > 0 {
+			p.log.InfoContext(ctx, "removed images", "count", purged)
+		}
+		if purged < p.cfg.BatchSize {
+			return
+		}
+	}
+}
+
+func (p *Purger) deleteObjects(ctx context.Context, img entities.Image) error {
+	keys := []string{img.Key}
+	if img.WebPKey != nil && *img.WebPKey != "" {
+		keys = append(keys, *img.WebPKey)
+	}
+
+	for _, prefix := range []string{keygen.DerivedPrefix(img.Key), keygen.VariantPrefix(img.Key)} {
+		objects, err := p.objects.List(ctx, prefix)
+		if err != nil {
+			p.log.ErrorContext(ctx, "list derived objects", "image_id", img.ID, "key", img.Key, "error", err)
+			return err
+		}
+		for _, obj := range objects {
+			keys = append(keys, obj.Key)
+		}
+	}
+
+	if err := p.objects.Delete(ctx, keys...); err != nil {
+		p.log.ErrorContext(ctx, "delete objects", "image_id", img.ID, "key", img.Key, "error", err)
+		return err
+	}
+	return nil
+}
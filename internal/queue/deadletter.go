@@ -0,0 +1,264 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/trunov/mediahub/internal/config"
+)
+
+var (
+	ErrDeadLetterNotFound  = errors.New("dead-lettered job not found")
+	ErrInvalidDeadLetterID = errors.New("invalid dead-lettered job id")
+)
+
+// replayBatch is how many dead letters ReplayAll reads at a time
+const replayBatch = 100
+
+// replayScript moves a dead letter back into the work stream with a fresh attempt counter.
+// The job is added before the dead letter is deleted, so a failing XADD leaves the dead letter in place.
+// KEYS: work stream, dead-letter stream. ARGV: dead letter id, max length of the work stream (0 for none).
+var replayScript = redis.NewScript(`
+local entries = redis.call('XRANGE', KEYS[2], ARGV[1], ARGV[1])
+if #entries == 0 then
+	return 0
+end
+
+local fields = entries[1][2]
+local job = ''
+for i = 1, #fields, 2 do
+	if fields[i] == 'job' then
+		job = fields[i + 1]
+	end
+end
+
+if tonumber(ARGV[2]) > 0 then
+	redis.call('XADD', KEYS[1], 'MAXLEN', ARGV[2], '*', 'job', job, 'attempt', 0)
+else
+	redis.call('XADD', KEYS[1], '*', 'job', job, 'attempt', 0)
+end
+redis.call('XDEL', KEYS[2], ARGV[1])
+return 1
+`)
+
+// DeadLetter is a job that failed for good, as stored in the dead-letter stream
+type DeadLetter struct {
+	ID             string          `json:"id"`
+	Type           string          `json:"type"`
+	Job            json.RawMessage `json:"job"`
+	Attempts       int             `json:"attempts"`
+	LastError      string          `json:"last_error"`
+	FirstAttemptAt time.Time       `json:"first_attempt_at"`
+	FailedAt       time.Time       `json:"failed_at"`
+	OriginalID     string          `json:"original_id"`
+}
+
+// DeadLetters inspects and replays the dead-letter stream of a worker
+type DeadLetters struct {
+	rc  redis.UniversalClient
+	cfg config.WebPWorkerConfig
+}
+
+func NewDeadLetters(rc redis.UniversalClient, cfg config.WebPWorkerConfig) *DeadLetters {
+	return &DeadLetters{rc: rc, cfg: cfg}
+}
+
+// List returns up to count dead letters, oldest first, starting after the given ID ("" for the beginning)
+func (d *DeadLetters) List(ctx context.Context, after string, count int64) ([]DeadLetter, error) {
+	start := "-"
+	if after != "" {
+		if !validID(after) {
+			return nil, ErrInvalidDeadLetterID
+		}
+		start = "(" + after
+	}
+
+	msgs, err := d.rc.XRangeN(ctx, d.cfg.DeadLetterKey(), start, "+", count).Result()
+	if err != nil {
+		return nil, fmt.Errorf("list dead letters: %w", err)
+	}
+
+	out := make([]DeadLetter, 0, len(msgs))
+	for _, m := range msgs {
+		out = append(out, toDeadLetter(m))
+	}
+	return out, nil
+}
+
+func (d *DeadLetters) Get(ctx context.Context, id string) (DeadLetter, error) {
+	if !validID(id) {
+		return DeadLetter{}, ErrInvalidDeadLetterID
+	}
+
+	msgs, err := d.rc.XRangeN(ctx, d.cfg.DeadLetterKey(), id, id, 1).Result()
+	if err != nil {
+		return DeadLetter{}, fmt.Errorf("get dead letter %s: %w", id, err)
+	}
+	if len(msgs) == 0 {
+		return DeadLetter{}, ErrDeadLetterNotFound
+	}
+	return toDeadLetter(msgs[0]), nil
+}
+
+// Count returns the number of dead letters
+func (d *DeadLetters) Count(ctx context.Context) (int64, error) {
+	return d.rc.XLen(ctx, d.cfg.DeadLetterKey()).Result()
+}
+
+// Replay puts the job back into the work stream with a fresh attempt counter and removes the dead letter, atomically.
+// In a Redis Cluster that needs both streams in one hash slot, e.g. webp_worker.stream "{webp}:jobs";
+// otherwise the job is added and the dead letter deleted in two steps, and a crash in between replays it twice.
+func (d *DeadLetters) Replay(ctx context.Context, id string) error {
+	if !validID(id) {
+		return ErrInvalidDeadLetterID
+	}
+
+	moved, err := replayScript.Run(ctx, d.rc, []string{d.cfg.Stream, d.cfg.DeadLetterKey()}, id, d.cfg.MaxLen).Int()
+	if err != nil && strings.Contains(err.Error(), "CROSSSLOT") {
+		return d.replayInSteps(ctx, id)
+	}
+	if err != nil {
+		return fmt.Errorf("replay dead letter %s: %w", id, err)
+	}
+	if moved == 0 {
+		return ErrDeadLetterNotFound
+	}
+	return nil
+}
+
+func (d *DeadLetters) replayInSteps(ctx context.Context, id string) error {
+	msgs, err := d.rc.XRangeN(ctx, d.cfg.DeadLetterKey(), id, id, 1).Result()
+	if err != nil {
+		return fmt.Errorf("replay dead letter %s: %w", id, err)
+	}
+	if len(msgs) == 0 {
+		return ErrDeadLetterNotFound
+	}
+	job, _ := msgs[0].Values["job"].(string)
+
+	err = d.rc.XAdd(ctx, &redis.XAddArgs{
+		Stream: d.cfg.Stream,
+		MaxLen: d.cfg.MaxLen,
+		Values: map[string]any{
+			"job":     job,
+			"attempt": 0,
+		},
+	}).Err()
+	if err != nil {
+		return fmt.Errorf("replay dead letter %s: %w", id, err)
+	}
+
+	return d.Purge(ctx, id)
+}
+
+// ReplayAll replays the dead letters present when it starts and returns how many were replayed.
+// Jobs that fail again meanwhile are dead-lettered behind that point and are left for the next call.
+func (d *DeadLetters) ReplayAll(ctx context.Context) (int, error) {
+	last, err := d.rc.XRevRangeN(ctx, d.cfg.DeadLetterKey(), "+", "-", 1).Result()
+	if err != nil {
+		return 0, fmt.Errorf("replay dead letters: %w", err)
+	}
+	if len(last) == 0 {
+		return 0, nil
+	}
+	end := last[0].ID
+
+	replayed := 0
+	start := "-"
+	for {
+		msgs, err := d.rc.XRangeN(ctx, d.cfg.DeadLetterKey(), start, end, replayBatch).Result()
+		if err != nil {
+			return replayed, fmt.Errorf("replay dead letters: %w", err)
+		}
+
+		for _, m := range msgs {
+			err := d.Replay(ctx, m.ID)
+			if errors.Is(err, ErrDeadLetterNotFound) {
+				continue // purged or replayed by someone else meanwhile
+			}
+			if err != nil {
+				return replayed, err
+			}
+			replayed++
+		}
+
+		if len(msgs) < replayBatch || msgs[len(msgs)-1].ID == end {
+			return replayed, nil
+		}
+		start = "(" + msgs[len(msgs)-1].ID
+	}
+}
+
+// Purge removes dead letters without replaying them
+func (d *DeadLetters) Purge(ctx context.Context, ids ...string) error {
+	for _, id := range ids {
+		if !validID(id) {
+			return ErrInvalidDeadLetterID
+		}
+	}
+
+	deleted, err := d.rc.XDel(ctx, d.cfg.DeadLetterKey(), ids...).Result()
+	if err != nil {
+		return fmt.Errorf("purge dead letters: %w", err)
+	}
+	if deleted == 0 {
+		return ErrDeadLetterNotFound
+	}
+	return nil
+}
+
+// PurgeAll removes every dead letter and returns how many there were
+func (d *DeadLetters) PurgeAll(ctx context.Context) (int64, error) {
+	n, err := d.Count(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if err := d.rc.Del(ctx, d.cfg.DeadLetterKey()).Err(); err != nil {
+		return 0, fmt.Errorf("purge dead letters: %w", err)
+	}
+	return n, nil
+}
+
+// validID reports whether id is a stream entry id, <ms> or <ms>-<seq>
+func validID(id string) bool {
+	ms, seq, hasSeq := strings.Cut(id, "-")
+	if _, err := strconv.ParseUint(ms, 10, 64); err != nil {
+		return false
+	}
+	if hasSeq {
+		if _, err := strconv.ParseUint(seq, 10, 64); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func toDeadLetter(m redis.XMessage) DeadLetter {
+	job, _ := m.Values["job"].(string)
+	typ, _ := m.Values["type"].(string)
+	lastErr, _ := m.Values["last_error"].(string)
+	originalID, _ := m.Values["original_id"].(string)
+
+	// undecodable jobs are dead-lettered as they came, keep them readable as a JSON string
+	raw := json.RawMessage(job)
+	if !json.Valid(raw) {
+		raw, _ = json.Marshal(job)
+	}
+
+	return DeadLetter{
+		ID:             m.ID,
+		Type:           typ,
+		Job:            raw,
+		Attempts:       toInt(m.Values["attempt"]),
+		LastError:      lastErr,
+		FirstAttemptAt: time.UnixMilli(int64(toInt(m.Values["first_attempt_at"]))).UTC(),
+		FailedAt:       time.UnixMilli(int64(toInt(m.Values["failed_at"]))).UTC(),
+		OriginalID:     originalID,
+	}
+}
@@ -0,0 +1,209 @@
+package queue
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"strings"
+
+	"github.com/trunov/mediahub/internal/config"
+	"github.com/trunov/mediahub/internal/entities"
+	"github.com/trunov/mediahub/internal/keygen"
+	"github.com/trunov/mediahub/internal/processor"
+	"github.com/trunov/mediahub/internal/tracing"
+	webp_converter "github.com/trunov/mediahub/internal/webp-converter"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const defaultVariantQuality = 80
+
+type Storage interface {
+	Get(ctx context.Context, key string) ([]byte, string, error)
+	Put(ctx context.Context, key, contentType string, payload []byte) error
+}
+
+// ImageStore records the outcome of image jobs on the image row
+type ImageStore interface {
+	MarkConverted(ctx context.Context, project string, userID int64, key string, webpKey string) error
+	MarkConversionFailed(ctx context.Context, project string, userID int64, key string) error
+	SaveVariant(ctx context.Context, project string, userID int64, key string, variant entities.ImageVariant) error
+}
+
+type WebPConverter interface {
+	ToWebP(reader io.Reader, ext string) ([]byte, error)
+}
+
+// ConvertHandler produces the WebP derivative of an uploaded image
+type ConvertHandler struct {
+	storage Storage
+	images  ImageStore
+	conv    WebPConverter
+	log     *slog.Logger
+}
+
+func NewConvertHandler(storage Storage, images ImageStore, logger *slog.Logger) *ConvertHandler {
+	return &ConvertHandler{
+		storage: storage,
+		images:  images,
+		conv:    webp_converter.Converter{},
+		log:     logger,
+	}
+}
+
+func (h *ConvertHandler) Handle(ctx context.Context, env Envelope) error {
+	job, err := decodeConvertJob(env)
+	if err != nil {
+		return err
+	}
+
+	orig, _, err := h.storage.Get(ctx, job.ObjectKey)
+	if err != nil {
+		return fmt.Errorf("download %s: %w", job.ObjectKey, err)
+	}
+
+	ext := strings.ToLower(job.Ext)
+	_, span := tracer.Start(ctx, "queue.ToWebP", trace.WithAttributes(attribute.String("image.ext", ext)))
+	webpBytes, err := h.conv.ToWebP(bytes.NewReader(orig), ext)
+	tracing.End(span, err)
+	if err != nil {
+		return fmt.Errorf("convert to webp: %w", err)
+	}
+
+	target := job.WebPKey
+	if target == "" {
+		target = job.ObjectKey + ".webp"
+	}
+
+	// Upload synchronously so a failed upload goes through the job retry path
+	// and the row only points at the WebP once the object actually exists
+	if err := h.storage.Put(ctx, target, "image/webp", webpBytes); err != nil {
+		return fmt.Errorf("upload webp: %w", err)
+	}
+
+	if err := h.images.MarkConverted(ctx, job.Project, job.UserID, job.ObjectKey, target); err != nil {
+		return fmt.Errorf("record webp key: %w", err)
+	}
+	return nil
+}
+
+// Failed flags the conversion as failed once the job ran out of attempts
+func (h *ConvertHandler) Failed(ctx context.Context, env Envelope, _ error) {
+	job, err := decodeConvertJob(env)
+	if err != nil {
+		return
+	}
+	if err := h.images.MarkConversionFailed(ctx, job.Project, job.UserID, job.ObjectKey); err != nil {
+		h.log.ErrorContext(ctx, "mark conversion as failed", "error", err)
+	}
+}
+
+func decodeConvertJob(env Envelope) (ConvertJob, error) {
+	var job ConvertJob
+	if env.Version != 1 {
+		return job, fmt.Errorf("%w: unsupported %s version %d", ErrPermanent, env.Type, env.Version)
+	}
+	if err := json.Unmarshal(env.Payload, &job); err != nil {
+		return job, fmt.Errorf("%w: decode %s payload: %v", ErrPermanent, env.Type, err)
+	}
+	return job, nil
+}
+
+// VariantsHandler renders the named variants configured for the image's project
+type VariantsHandler struct {
+	storage Storage
+	images  ImageStore
+}
+
+func NewVariantsHandler(storage Storage, images ImageStore) *VariantsHandler {
+	return &VariantsHandler{
+		storage: storage,
+		images:  images,
+	}
+}
+
+func (h *VariantsHandler) Handle(ctx context.Context, env Envelope) error {
+	if env.Version != 1 {
+		return fmt.Errorf("%w: unsupported %s version %d", ErrPermanent, env.Type, env.Version)
+	}
+	var job VariantsJob
+	if err := json.Unmarshal(env.Payload, &job); err != nil {
+		return fmt.Errorf("%w: decode %s payload: %v", ErrPermanent, env.Type, err)
+	}
+
+	orig, _, err := h.storage.Get(ctx, job.ObjectKey)
+	if err != nil {
+		return fmt.Errorf("download %s: %w", job.ObjectKey, err)
+	}
+
+	for _, preset := range job.Variants {
+		// jobs carry the presets of enqueue time, which may predate the checks of the config
+		if !preset.ValidName() {
+			return fmt.Errorf("%w: invalid variant name %q", ErrPermanent, preset.Name)
+		}
+		if preset.Width > math.MaxInt16 || preset.Height > math.MaxInt16 {
+			return fmt.Errorf("%w: variant %s size %dx%d exceeds %d", ErrPermanent, preset.Name, preset.Width, preset.Height, math.MaxInt16)
+		}
+	}
+
+	for _, preset := range job.Variants {
+		if err := h.generate(ctx, job, orig, preset); err != nil {
+			return fmt.Errorf("variant %s: %w", preset.Name, err)
+		}
+	}
+	return nil
+}
+
+// generate renders one preset from the original and records it on the image.
+// Variant keys are predictable, so a retried job simply overwrites what it produced before.
+func (h *VariantsHandler) generate(ctx context.Context, job VariantsJob, orig []byte, preset config.VariantPreset) (err error) {
+	ctx, span := tracer.Start(ctx, "queue.generateVariant", trace.WithAttributes(attribute.String("mediahub.variant", preset.Name)))
+	defer func() { tracing.End(span, err) }()
+
+	img, err := processor.LoadImage(ctx, bytes.NewReader(orig), &processor.ImageResizer{
+		Width:  preset.Width,
+		Height: preset.Height,
+		Fit:    preset.Fit,
+	})
+	if err != nil {
+		return fmt.Errorf("decode: %w", err)
+	}
+
+	// image_variants.width and height are SMALLINT, a kept aspect ratio can still overshoot
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+	if width > math.MaxInt16 || height > math.MaxInt16 {
+		return fmt.Errorf("%w: variant %dx%d exceeds %d", ErrPermanent, width, height, math.MaxInt16)
+	}
+
+	format := preset.Format
+	if format == "" {
+		format = processor.FormatWEBP
+	}
+	quality := preset.Quality
+	if quality == 0 {
+		quality = defaultVariantQuality
+	}
+
+	data, err := processor.Encode(ctx, img, format, quality)
+	if err != nil {
+		return fmt.Errorf("encode: %w", err)
+	}
+
+	variant := entities.ImageVariant{
+		Name:     preset.Name,
+		Key:      keygen.VariantKey(job.ObjectKey, preset.Name, format),
+		Width:    int16(width),
+		Height:   int16(height),
+		Size:     int32(len(data)),
+		MimeType: processor.ContentType(format),
+	}
+
+	if err := h.storage.Put(ctx, variant.Key, variant.MimeType, data); err != nil {
+		return fmt.Errorf("upload: %w", err)
+	}
+	return h.images.SaveVariant(ctx, job.Project, job.UserID, job.ObjectKey, variant)
+}
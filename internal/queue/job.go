@@ -1,10 +1,57 @@
 package queue
 
-// ConvertJob is what we push to Redis Streams.
+import (
+	"encoding/json"
+
+	"github.com/trunov/mediahub/internal/config"
+)
+
+// Job types known to the worker
+const (
+	JobTypeConvert  = "webp.convert"
+	JobTypeVariants = "image.variants"
+)
+
+// Envelope is what we push to Redis Streams.
+// Type selects the registered Handler, Version lets a handler evolve its payload format,
+// and jobs sharing an IdempotencyKey are only processed successfully once.
+// TraceContext carries the W3C trace headers of the enqueuing request, the job span links back to it.
+type Envelope struct {
+	Type           string            `json:"type"`
+	Version        int               `json:"version"`
+	IdempotencyKey string            `json:"idempotency_key,omitempty"`
+	TraceContext   map[string]string `json:"trace_context,omitempty"`
+	Payload        json.RawMessage   `json:"payload"`
+}
+
+// ConvertJob is the payload of JobTypeConvert.
 // No bytes here—workers fetch by ObjectKey.
 type ConvertJob struct {
+	ImageID     int64  `json:"image_id,omitempty"` // the image row, unique per upload even when a key is reused
+	Project     string `json:"project"`            // project, user_id and object_key identify the image row
+	UserID      int64  `json:"user_id"`
 	ObjectKey   string `json:"object_key"`
 	ContentType string `json:"content_type"`
 	Ext         string `json:"ext"`                // ".jpg" | ".jpeg" | ".png"
 	WebPKey     string `json:"webp_key,omitempty"` // optional override (defaults to ObjectKey + ".webp")
 }
+
+// VariantsJob is the payload of JobTypeVariants
+type VariantsJob struct {
+	ImageID   int64  `json:"image_id,omitempty"`
+	Project   string `json:"project"`
+	UserID    int64  `json:"user_id"`
+	ObjectKey string `json:"object_key"`
+
+	// Variants are the project presets at upload time
+	Variants []config.VariantPreset `json:"variants"`
+}
+
+// objectKey returns the object_key of image job payloads, for logging
+func objectKey(env Envelope) string {
+	var ref struct {
+		ObjectKey string `json:"object_key"`
+	}
+	_ = json.Unmarshal(env.Payload, &ref)
+	return ref.ObjectKey
+}
@@ -3,10 +3,19 @@ package queue
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"strconv"
 
 	"github.com/redis/go-redis/v9"
+	"github.com/trunov/mediahub/internal/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
+var tracer = otel.Tracer("github.com/trunov/mediahub/internal/queue")
+
 type Producer struct {
 	r      redis.UniversalClient
 	stream string
@@ -17,16 +26,56 @@ func NewProducer(r redis.UniversalClient, stream string, maxLen int64) *Producer
 	return &Producer{r: r, stream: stream, maxLen: maxLen}
 }
 
-// Encodes it as JSON and appends it to a Redis Stream
-// Persist the conversion request for background processing
-func (p *Producer) EnqueueConvert(ctx context.Context, job ConvertJob) error {
-	raw, _ := json.Marshal(job)
+// Enqueue wraps the payload into an Envelope, encodes it as JSON and appends it to a Redis Stream
+// Persist the request for background processing
+func (p *Producer) Enqueue(ctx context.Context, jobType string, version int, idempotencyKey string, payload any) (err error) {
+	ctx, span := tracer.Start(ctx, "queue.Enqueue "+jobType, trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(attribute.String("messaging.destination.name", p.stream)))
+	defer func() { tracing.End(span, err) }()
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("encode %s payload: %w", jobType, err)
+	}
+
+	traceContext := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, traceContext)
+
+	raw, err := json.Marshal(Envelope{
+		Type:           jobType,
+		Version:        version,
+		IdempotencyKey: idempotencyKey,
+		TraceContext:   traceContext,
+		Payload:        body,
+	})
+	if err != nil {
+		return fmt.Errorf("encode %s envelope: %w", jobType, err)
+	}
+
 	return p.r.XAdd(ctx, &redis.XAddArgs{
 		Stream: p.stream,
 		MaxLen: p.maxLen,
 		Values: map[string]any{
-			"payload": string(raw),
+			"job":     string(raw),
 			"attempt": 0,
 		},
 	}).Err()
 }
+
+func (p *Producer) EnqueueConvert(ctx context.Context, job ConvertJob) error {
+	return p.Enqueue(ctx, JobTypeConvert, 1, imageIdempotencyKey(JobTypeConvert, job.ImageID), job)
+}
+
+func (p *Producer) EnqueueVariants(ctx context.Context, job VariantsJob) error {
+	return p.Enqueue(ctx, JobTypeVariants, 1, imageIdempotencyKey(JobTypeVariants, job.ImageID), job)
+}
+
+// imageIdempotencyKey identifies the job of one upload. Object keys are deterministic and come back
+// when the same content is uploaded again after a purge, the image id doesn't.
+// Without an image id the job is not deduplicated.
+func imageIdempotencyKey(jobType string, imageID int64) string {
+	if imageID == 0 {
+		return ""
+	}
+	return jobType + ":" + strconv.FormatInt(imageID, 10)
+}
@@ -0,0 +1,176 @@
+package queue
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// promoteInterval is how often due retries are moved from the delayed set back into the stream
+	promoteInterval = time.Second
+	promoteBatch    = 100
+	// promoteClaimTTL is how long a replica owns a due retry it is moving back into the stream
+	promoteClaimTTL = 30 * time.Second
+)
+
+// delayedJob is a member of the delayed sorted set, scored by the time it becomes due
+type delayedJob struct {
+	Job            string `json:"job"`
+	Attempt        int    `json:"attempt"`
+	FirstAttemptAt int64  `json:"first_attempt_at"` // unix milliseconds
+	LastError      string `json:"last_error"`
+	OriginalID     string `json:"original_id"` // stream ID of the failed delivery, keeps members unique
+}
+
+// scheduleRetry persists the job in the delayed set before the failed delivery is acknowledged,
+// so a restart during backoff can at worst duplicate the job but never lose it.
+func (w *Worker) scheduleRetry(ctx context.Context, m redis.XMessage, raw string, attempt int, firstAttemptAt int64, jobErr error) error {
+	member, err := json.Marshal(delayedJob{
+		Job:            raw,
+		Attempt:        attempt + 1,
+		FirstAttemptAt: firstAttemptAt,
+		LastError:      jobErr.Error(),
+		OriginalID:     m.ID,
+	})
+	if err != nil {
+		return err
+	}
+
+	// simple exponential backoff
+	due := time.Now().Add(w.cfg.BackoffBase.Duration() << attempt)
+
+	return w.rc.ZAdd(ctx, w.cfg.DelayedKey(), redis.Z{
+		Score:  float64(due.UnixMilli()),
+		Member: string(member),
+	}).Err()
+}
+
+// deadLetter moves a job that failed for good to the dead-letter stream
+func (w *Worker) deadLetter(ctx context.Context, m redis.XMessage, raw string, env Envelope, attempt int, firstAttemptAt int64, jobErr error) error {
+	return w.rc.XAdd(ctx, &redis.XAddArgs{
+		Stream: w.cfg.DeadLetterKey(),
+		MaxLen: w.cfg.DeadLetterMaxLen,
+		Values: map[string]any{
+			"job":              raw,
+			"type":             env.Type,
+			"attempt":          attempt + 1,
+			"last_error":       jobErr.Error(),
+			"first_attempt_at": firstAttemptAt,
+			"failed_at":        time.Now().UnixMilli(),
+			"original_id":      m.ID,
+		},
+	}).Err()
+}
+
+// deadLetterUndecodable moves a message that can't be decoded to the dead-letter stream as it is, so it can be inspected
+func (w *Worker) deadLetterUndecodable(ctx context.Context, raw string, originalID string, decodeErr error) error {
+	return w.rc.XAdd(ctx, &redis.XAddArgs{
+		Stream: w.cfg.DeadLetterKey(),
+		MaxLen: w.cfg.DeadLetterMaxLen,
+		Values: map[string]any{
+			"job":         raw,
+			"type":        "",
+			"attempt":     0,
+			"last_error":  fmt.Errorf("%w: %v", ErrPermanent, decodeErr).Error(),
+			"failed_at":   time.Now().UnixMilli(),
+			"original_id": originalID,
+		},
+	}).Err()
+}
+
+// promoteLoop periodically moves due retries back into the stream until ctx is canceled
+func (w *Worker) promoteLoop(ctx context.Context) {
+	t := time.NewTicker(promoteInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if err := w.promoteDue(ctx); err != nil && ctx.Err() == nil {
+				w.log.Error("promote delayed jobs", "error", err)
+			}
+		}
+	}
+}
+
+// promoteDue re-enqueues every retry whose backoff has elapsed.
+// A member is added to the stream before it is removed from the delayed set, so a crash in between
+// duplicates the job instead of losing it. The stream and the set may live on different cluster nodes,
+// which rules out moving it in one script; a short claim keeps replicas from promoting the same member.
+func (w *Worker) promoteDue(ctx context.Context) error {
+	for {
+		members, err := w.rc.ZRangeByScore(ctx, w.cfg.DelayedKey(), &redis.ZRangeBy{
+			Min:   "-inf",
+			Max:   strconv.FormatInt(time.Now().UnixMilli(), 10),
+			Count: promoteBatch,
+		}).Result()
+		if err != nil {
+			return err
+		}
+
+		promoted := 0
+		for _, member := range members {
+			ok, err := w.promote(ctx, member)
+			if err != nil {
+				return err
+			}
+			if ok {
+				promoted++
+			}
+		}
+
+		// members claimed by other replicas stay in the set until they are done with them
+		if len(members) < promoteBatch || promoted == 0 {
+			return nil
+		}
+	}
+}
+
+// promote moves one member into the stream, or into the dead-letter stream if it can't be decoded.
+// It reports false if another replica holds the claim of the member.
+func (w *Worker) promote(ctx context.Context, member string) (bool, error) {
+	claim := w.promoteClaimKey(member)
+	claimed, err := w.rc.SetNX(ctx, claim, 1, promoteClaimTTL).Result()
+	if err != nil || !claimed {
+		return false, err
+	}
+
+	var dj delayedJob
+	if err := json.Unmarshal([]byte(member), &dj); err != nil {
+		w.log.Error("dead-letter undecodable delayed job", "error", err)
+		err = w.deadLetterUndecodable(ctx, member, "", err)
+	} else {
+		err = w.rc.XAdd(ctx, &redis.XAddArgs{
+			Stream: w.cfg.Stream,
+			MaxLen: w.cfg.MaxLen,
+			Values: map[string]any{
+				"job":              dj.Job,
+				"attempt":          dj.Attempt,
+				"first_attempt_at": dj.FirstAttemptAt,
+				"last_error":       dj.LastError,
+			},
+		}).Err()
+	}
+	if err != nil {
+		// let the next tick retry right away instead of after the claim expired
+		_ = w.rc.Del(ctx, claim).Err()
+		return false, err
+	}
+
+	// the claim outlives the member, so a slow replica that read it before the ZREM can't add it again
+	return true, w.rc.ZRem(ctx, w.cfg.DelayedKey(), member).Err()
+}
+
+func (w *Worker) promoteClaimKey(member string) string {
+	sum := sha256.Sum256([]byte(member))
+	return w.cfg.DelayedKey() + ":claim:" + hex.EncodeToString(sum[:16])
+}
@@ -0,0 +1,43 @@
+package queue
+
+import (
+	"context"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/trunov/mediahub/internal/config"
+	"github.com/trunov/mediahub/internal/metrics"
+)
+
+// Stats reads the length of the job stream, the pending entries and lag of its consumer group,
+// and the number of delayed retries and dead letters
+func Stats(ctx context.Context, rc redis.UniversalClient, cfg config.WebPWorkerConfig) (metrics.StreamStats, error) {
+	pipe := rc.Pipeline()
+	length := pipe.XLen(ctx, cfg.Stream)
+	groups := pipe.XInfoGroups(ctx, cfg.Stream)
+	delayed := pipe.ZCard(ctx, cfg.DelayedKey())
+	dead := pipe.XLen(ctx, cfg.DeadLetterKey())
+	// XINFO GROUPS fails while the stream doesn't exist yet, the other commands just return 0
+	_, _ = pipe.Exec(ctx)
+
+	var s metrics.StreamStats
+	var err error
+	if s.Length, err = length.Result(); err != nil {
+		return s, err
+	}
+	if s.Delayed, err = delayed.Result(); err != nil {
+		return s, err
+	}
+	if s.DeadLetters, err = dead.Result(); err != nil {
+		return s, err
+	}
+
+	// until the group exists every entry counts as lag
+	s.Lag = s.Length
+	infos, _ := groups.Result()
+	for _, g := range infos {
+		if g.Name == cfg.Group {
+			s.Pending, s.Lag = g.Pending, g.Lag
+		}
+	}
+	return s, nil
+}
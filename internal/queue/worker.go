@@ -1,56 +1,113 @@
 package queue
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
-	"log"
+	"log/slog"
+	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/redis/go-redis/v9"
 	"github.com/trunov/mediahub/internal/config"
-	webp_converter "github.com/trunov/mediahub/internal/webp-converter"
+	"github.com/trunov/mediahub/internal/logging"
+	"github.com/trunov/mediahub/internal/metrics"
+	"github.com/trunov/mediahub/internal/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
-type Storage interface {
-	Download(ctx context.Context, key string) ([]byte, string, error)
-	UploadWithHook(ctx context.Context, key, contentType string, payload []byte, onSuccess func()) error
+// ErrPermanent marks job failures that retrying cannot fix, e.g. an undecodable payload
+var ErrPermanent = errors.New("permanent job failure")
+
+const defaultReclaimInterval = 30 * time.Second
+
+// staleConsumerIdle is how long a consumer without pending messages stays in the group,
+// consumers of replaced processes are removed after that
+const staleConsumerIdle = 24 * time.Hour
+
+// idempotencyTTL is how long a processed idempotency key suppresses duplicate jobs
+const idempotencyTTL = 24 * time.Hour
+
+// Handler processes one job type
+type Handler interface {
+	Handle(ctx context.Context, job Envelope) error
 }
 
-type WebPConverter interface {
-	ToWebP(reader io.Reader, ext string) ([]byte, error)
+// FailureHandler is optionally implemented by handlers that need to react
+// once a job has failed for good (attempts exhausted or a permanent error)
+type FailureHandler interface {
+	Failed(ctx context.Context, job Envelope, err error)
 }
 
+// Worker consumes jobs from a Redis Stream consumer group and dispatches them by type to the registered handlers
 type Worker struct {
-	rc      redis.UniversalClient
-	cfg     config.WebPWorkerConfig
-	storage Storage
-	conv    WebPConverter
+	rc       redis.UniversalClient
+	cfg      config.WebPWorkerConfig
+	consumer string // unique per process
+	handlers map[string]Handler
+	done     chan struct{}
+	log      *slog.Logger
+
+	// messages this process is handling or has claimed to handle next, by number of holders;
+	// never reclaimed by it and kept from idling for other replicas
+	inFlightMu sync.Mutex
+	inFlight   map[string]int
+
+	// consumer loops, resized by Scale
+	mu      sync.Mutex
+	loopCtx context.Context
+	loops   []context.CancelFunc
+	loopsWg sync.WaitGroup
+	nextID  int
 }
 
-func Init(ctx context.Context, rc redis.UniversalClient, cfg config.WebPWorkerConfig, r2Storage Storage) *Producer {
+// Init starts a worker with the image job handlers registered and returns it with the producer for its stream
+func Init(ctx context.Context, rc redis.UniversalClient, cfg config.WebPWorkerConfig, storage Storage, images ImageStore, logger *slog.Logger) (*Producer, *Worker) {
 	producer := NewProducer(rc, cfg.Stream, cfg.MaxLen)
-	worker := NewWorker(rc, cfg, r2Storage)
+
+	worker := NewWorker(rc, cfg, logger)
+	worker.Register(JobTypeConvert, NewConvertHandler(storage, images, worker.log))
+	worker.Register(JobTypeVariants, NewVariantsHandler(storage, images))
 
 	go func() {
 		if err := worker.Start(ctx); err != nil {
-			log.Printf("[webp-worker] stopped: %v", err)
+			worker.log.Error("stopped", "error", err)
 		}
 	}()
 
-	return producer
+	return producer, worker
 }
 
-func NewWorker(rc redis.UniversalClient, cfg config.WebPWorkerConfig, storage Storage) *Worker {
+func NewWorker(rc redis.UniversalClient, cfg config.WebPWorkerConfig, logger *slog.Logger) *Worker {
 	return &Worker{
-		rc:      rc,
-		cfg:     cfg,
-		storage: storage,
-		conv:    webp_converter.Converter{},
+		rc:       rc,
+		cfg:      cfg,
+		consumer: consumerName(cfg.Consumer),
+		handlers: make(map[string]Handler),
+		done:     make(chan struct{}),
+		log:      logger.With("component", "queue", "stream", cfg.Stream),
+		inFlight: make(map[string]int),
+	}
+}
+
+// consumerName makes the configured consumer name unique per process, so replicas and restarts never share one
+func consumerName(base string) string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "unknown"
 	}
+	return fmt.Sprintf("%s-%s-%d", base, host, os.Getpid())
+}
+
+// Register sets the handler for a job type. It must be called before Start.
+func (w *Worker) Register(jobType string, h Handler) {
+	w.handlers[jobType] = h
 }
 
 func (w *Worker) EnsureGroup(ctx context.Context) error {
@@ -63,85 +120,369 @@ func (w *Worker) EnsureGroup(ctx context.Context) error {
 	return nil
 }
 
+// Start consumes the stream until ctx is canceled.
+// Cancellation only stops reading new messages; jobs already picked up run to completion and get acknowledged.
 func (w *Worker) Start(ctx context.Context) error {
+	defer close(w.done)
+
 	if err := w.EnsureGroup(ctx); err != nil {
 		return fmt.Errorf("failed to ensure Redis group: %w", err)
 	}
 
-	log.Printf("[webp-worker] starting consumer group=%s stream=%s workers=%d",
-		w.cfg.Group, w.cfg.Stream, w.cfg.Workers,
-	)
+	w.log.Info("starting consumer", "group", w.cfg.Group, "consumer", w.consumer)
 
-	// Adopt orphaned pending messages
+	// Adopt orphaned pending messages, then keep doing so for replicas that die while we run
 	w.autoClaim(ctx)
-	log.Printf("[webp-worker] auto-claim complete, entering loop...")
-
-	errCh := make(chan error, w.cfg.Workers)
-	for i := 0; i < w.cfg.Workers; i++ {
-		id := i
-		go func() {
-			log.Printf("[webp-worker] worker #%d started", id)
-			err := w.loop(ctx)
-			if err != nil {
-				log.Printf("[webp-worker] worker #%d stopped with error: %v", id, err)
-			} else {
-				log.Printf("[webp-worker] worker #%d stopped gracefully", id)
+	w.log.Info("auto-claim complete, entering loop")
+	var background sync.WaitGroup
+	defer background.Wait()
+
+	background.Add(3)
+	go func() {
+		defer background.Done()
+		w.reclaimLoop(ctx)
+	}()
+
+	// Keep the jobs we are running from looking abandoned to other replicas
+	go func() {
+		defer background.Done()
+		w.heartbeatLoop(ctx)
+	}()
+
+	// Move retries whose backoff elapsed back into the stream
+	go func() {
+		defer background.Done()
+		w.promoteLoop(ctx)
+	}()
+
+	w.mu.Lock()
+	w.loopCtx = ctx
+	for len(w.loops) < w.cfg.Workers {
+		w.spawn()
+	}
+	w.mu.Unlock()
+
+	<-ctx.Done()
+	w.log.Info("context canceled, waiting for running jobs to finish")
+
+	// Scale doesn't spawn loops once ctx is done, so nothing is added to loopsWg past this point
+	w.mu.Lock()
+	w.loops = nil
+	w.mu.Unlock()
+
+	w.loopsWg.Wait()
+	return nil
+}
+
+// Scale changes the number of consumer loops of a running worker.
+// A removed loop stops reading new messages and finishes the job it is running.
+func (w *Worker) Scale(workers int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.loopCtx == nil {
+		w.cfg.Workers = workers
+		return
+	}
+	if w.loopCtx.Err() != nil {
+		return
+	}
+
+	w.log.Info("scaling workers", "from", len(w.loops), "to", workers)
+	for len(w.loops) < workers {
+		w.spawn()
+	}
+	for len(w.loops) > workers {
+		last := len(w.loops) - 1
+		w.loops[last]()
+		w.loops = w.loops[:last]
+	}
+	w.cfg.Workers = workers
+}
+
+// spawn starts one consumer loop, w.mu must be held
+func (w *Worker) spawn() {
+	ctx, cancel := context.WithCancel(w.loopCtx)
+	w.loops = append(w.loops, cancel)
+
+	id := w.nextID
+	w.nextID++
+
+	w.loopsWg.Add(1)
+	go func() {
+		defer w.loopsWg.Done()
+		defer cancel()
+
+		w.log.Info("worker started", "worker", id)
+		if err := w.loop(ctx); err != nil {
+			w.log.Error("worker stopped", "worker", id, "error", err)
+		} else {
+			w.log.Info("worker stopped", "worker", id)
+		}
+	}()
+}
+
+// Wait blocks until Start has returned, i.e. every job picked up before shutdown is finished
+func (w *Worker) Wait() {
+	<-w.done
+}
+
+// reclaimLoop periodically adopts and processes messages that other consumers
+// received but never acknowledged, e.g. because their replica crashed mid-job.
+func (w *Worker) reclaimLoop(ctx context.Context) {
+	interval := w.cfg.ReclaimInterval.Duration()
+	if interval <= 0 {
+		interval = defaultReclaimInterval
+	}
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			w.autoClaim(ctx)
+			w.removeStaleConsumers(ctx)
+		}
+	}
+}
+
+// heartbeatLoop re-claims the messages this process is handling well within minIdle,
+// which resets their idle time, so other replicas don't reclaim slow jobs that are still running
+func (w *Worker) heartbeatLoop(ctx context.Context) {
+	t := time.NewTicker(w.minIdle() / 3)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			ids := w.inFlightIDs()
+			if len(ids) == 0 {
+				continue
+			}
+			// JUSTID leaves the delivery counter alone
+			err := w.rc.XClaimJustID(ctx, &redis.XClaimArgs{
+				Stream:   w.cfg.Stream,
+				Group:    w.cfg.Group,
+				Consumer: w.consumer,
+				Messages: ids,
+			}).Err()
+			if err != nil && ctx.Err() == nil {
+				w.log.Error("heartbeat", "error", err)
 			}
-			errCh <- err
-		}()
+		}
 	}
+}
 
-	select {
-	case <-ctx.Done():
-		log.Printf("[webp-worker] context canceled, stopping all workers")
-		return ctx.Err()
-	case err := <-errCh:
-		if err != nil {
-			return fmt.Errorf("worker loop exited with error: %w", err)
+// removeStaleConsumers deletes consumers of processes that are gone; their pending messages are reclaimed first
+func (w *Worker) removeStaleConsumers(ctx context.Context) {
+	consumers, err := w.rc.XInfoConsumers(ctx, w.cfg.Stream, w.cfg.Group).Result()
+	if err != nil {
+		if ctx.Err() == nil {
+			w.log.Error("list consumers", "error", err)
 		}
-		return nil
+		return
 	}
+
+	for _, c := range consumers {
+		if c.Name == w.consumer || c.Pending > 0 || c.Idle < staleConsumerIdle {
+			continue
+		}
+		if err := w.rc.XGroupDelConsumer(ctx, w.cfg.Stream, w.cfg.Group, c.Name).Err(); err != nil {
+			w.log.Error("remove stale consumer", "consumer", c.Name, "error", err)
+			continue
+		}
+		w.log.Info("removed stale consumer", "consumer", c.Name, "idle", c.Idle)
+	}
+}
+
+// track marks a message as being handled by this process, the returned func unmarks it
+func (w *Worker) track(id string) func() {
+	w.inFlightMu.Lock()
+	w.inFlight[id]++
+	w.inFlightMu.Unlock()
+
+	return func() { w.untrack(id) }
+}
+
+// trackBatch marks the messages that are not in flight yet and returns them,
+// each has to be released with untrack once it is handled or skipped
+func (w *Worker) trackBatch(msgs []redis.XMessage) []redis.XMessage {
+	w.inFlightMu.Lock()
+	defer w.inFlightMu.Unlock()
+
+	batch := make([]redis.XMessage, 0, len(msgs))
+	for _, m := range msgs {
+		if w.inFlight[m.ID] > 0 {
+			continue
+		}
+		w.inFlight[m.ID]++
+		batch = append(batch, m)
+	}
+	return batch
+}
+
+func (w *Worker) untrack(id string) {
+	w.inFlightMu.Lock()
+	defer w.inFlightMu.Unlock()
+
+	if w.inFlight[id]--; w.inFlight[id] <= 0 {
+		delete(w.inFlight, id)
+	}
+}
+
+func (w *Worker) inFlightIDs() []string {
+	w.inFlightMu.Lock()
+	defer w.inFlightMu.Unlock()
+
+	ids := make([]string, 0, len(w.inFlight))
+	for id := range w.inFlight {
+		ids = append(ids, id)
+	}
+	return ids
 }
 
 // autoClaim scans the Redis Stream's consumer group for "stuck" messages
 // that were previously delivered to other consumers but never acknowledged.
 // This can happen if a worker crashes or is killed before XACK.
-// Using XAUTOCLAIM, we take ownership of those idle messages so they can be retried.
+// Using XAUTOCLAIM, we take ownership of those idle messages and process them right away.
+// Messages a loop of this process is still handling are skipped. The claimed batch is in flight
+// until each message is handled, so the heartbeat keeps the waiting ones from being reclaimed again.
 //
-// This ensures that incomplete jobs are not lost and will eventually
-// be picked up again after a restart or worker failure.
+// Messages delivered more than MaxDeliveries times (according to XPENDING) are
+// poison—most likely they crash whoever handles them—and go to the dead-letter stream instead.
 func (w *Worker) autoClaim(ctx context.Context) {
 	next := "0-0"
+	minIdle := w.minIdle()
 
-	// Determine how long a message must have been idle before we reclaim it.
-	// Default to 30 seconds minimum; increase proportionally to the block timeout
-	// (so we don't steal messages still being processed by slow workers).
-	minIdle := 30 * time.Second
-	if w.cfg.BlockTimeout > 0 {
-		t := w.cfg.BlockTimeout * 6
-		if t > minIdle {
-			minIdle = t
-		}
-	}
-
-	for {
+	for ctx.Err() == nil {
 		// Try to claim up to 100 idle messages from other consumers
 		// in the same group that have been pending longer than minIdle.
 		msgs, start, err := w.rc.XAutoClaim(ctx, &redis.XAutoClaimArgs{
 			Stream:   w.cfg.Stream,
 			Group:    w.cfg.Group,
-			Consumer: w.cfg.Consumer,
+			Consumer: w.consumer,
 			MinIdle:  minIdle,
 			Start:    next,
 			Count:    100,
 		}).Result()
-		if err != nil || len(msgs) == 0 {
+		if err != nil {
+			if ctx.Err() == nil {
+				w.log.Error("auto-claim", "error", err)
+			}
+			return
+		}
+		if len(msgs) > 0 {
+			w.log.Info("reclaimed pending messages", "count", len(msgs))
+		}
+
+		batch := w.trackBatch(msgs)
+		deliveries := w.deliveryCounts(ctx, batch)
+		for i, m := range batch {
+			// messages we don't get to stay pending and are reclaimed by another replica
+			if ctx.Err() != nil {
+				for _, rest := range batch[i:] {
+					w.untrack(rest.ID)
+				}
+				return
+			}
+			if count := deliveries[m.ID]; count > int64(w.maxDeliveries()) {
+				w.deadLetterPoison(ctx, m, count)
+			} else {
+				// should report error to sentry
+				_ = w.handle(context.WithoutCancel(ctx), m)
+			}
+			w.untrack(m.ID)
+		}
+
+		// XAUTOCLAIM returns "0-0" once the whole PEL has been scanned
+		if start == "0-0" || start == "" {
 			return
 		}
 		next = start
 	}
 }
 
+// deliveryCounts looks up how often each claimed message has been delivered.
+// Each message is queried on its own, a range could be filled up by other pending messages.
+func (w *Worker) deliveryCounts(ctx context.Context, msgs []redis.XMessage) map[string]int64 {
+	counts := make(map[string]int64, len(msgs))
+	if len(msgs) == 0 {
+		return counts
+	}
+
+	cmds := make([]*redis.XPendingExtCmd, len(msgs))
+	_, err := w.rc.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, m := range msgs {
+			cmds[i] = pipe.XPendingExt(ctx, &redis.XPendingExtArgs{
+				Stream: w.cfg.Stream,
+				Group:  w.cfg.Group,
+				Start:  m.ID,
+				End:    m.ID,
+				Count:  1,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		w.log.Error("xpending", "error", err)
+		return counts
+	}
+
+	for _, cmd := range cmds {
+		for _, p := range cmd.Val() {
+			counts[p.ID] = p.RetryCount
+		}
+	}
+	return counts
+}
+
+func (w *Worker) deadLetterPoison(ctx context.Context, m redis.XMessage, deliveries int64) {
+	poisonErr := fmt.Errorf("%w: delivered %d times without being acknowledged", ErrPermanent, deliveries)
+	w.log.Warn("poison message", "job_id", m.ID, "deliveries", deliveries)
+
+	raw, env, err := decodeMessage(m)
+	if err == nil {
+		firstAttemptAt := int64(toInt(m.Values["first_attempt_at"]))
+		err = w.deadLetter(ctx, m, raw, env, toInt(m.Values["attempt"]), firstAttemptAt, poisonErr)
+	} else {
+		err = w.deadLetterUndecodable(ctx, rawMessage(m), m.ID, err)
+	}
+	if err != nil {
+		w.log.Error("dead-letter poison message", "job_id", m.ID, "error", err)
+		return
+	}
+	_ = w.ack(ctx, m)
+}
+
+// minIdle is how long a message must have been pending before we reclaim it.
+// Default to 30 seconds minimum; increase proportionally to the block timeout
+// (so we don't steal messages still being processed by slow workers).
+func (w *Worker) minIdle() time.Duration {
+	minIdle := 30 * time.Second
+	if w.cfg.BlockTimeout > 0 {
+		t := w.cfg.BlockTimeout.Duration() * 6
+		if t > minIdle {
+			minIdle = t
+		}
+	}
+	return minIdle
+}
+
+func (w *Worker) maxDeliveries() int {
+	if w.cfg.MaxDeliveries > 0 {
+		return w.cfg.MaxDeliveries
+	}
+	if w.cfg.MaxAttempts > 0 {
+		return w.cfg.MaxAttempts
+	}
+	return 1
+}
+
 func (w *Worker) loop(ctx context.Context) error {
 	for {
 		// XREADGROUP is where the actual "delivery" happens.
@@ -153,16 +494,16 @@ func (w *Worker) loop(ctx context.Context) error {
 		//   3. Returns them to this worker for processing.
 		//
 		// The message stays in the PEL until we explicitly acknowledge it with XACK,
-		// which happens at the end of handle() via a deferred call.
+		// which happens at the end of handle().
 		//
 		// If the worker crashes before XACK, the message remains pending and
-		// will later be reclaimed by autoClaim() on startup.
+		// will later be reclaimed by autoClaim(), at startup or from reclaimLoop().
 		streams, err := w.rc.XReadGroup(ctx, &redis.XReadGroupArgs{
 			Group:    w.cfg.Group,
-			Consumer: w.cfg.Consumer,
+			Consumer: w.consumer,
 			Streams:  []string{w.cfg.Stream, ">"},
 			Count:    1,
-			Block:    w.cfg.BlockTimeout,
+			Block:    w.cfg.BlockTimeout.Duration(),
 		}).Result()
 		if err != nil && err != redis.Nil {
 			if ctx.Err() != nil {
@@ -172,71 +513,153 @@ func (w *Worker) loop(ctx context.Context) error {
 		}
 		for _, s := range streams {
 			for _, m := range s.Messages {
+				// a received job is finished even if shutdown starts meanwhile
 				// should report error to sentry
-				_ = w.handle(ctx, m)
+				_ = w.handle(context.WithoutCancel(ctx), m)
 			}
 		}
 	}
 }
 
+// handle runs the job of one delivery and always acknowledges it afterwards.
+// A failed job is acknowledged only after it was persisted in the delayed set or the dead-letter stream;
+// if that write fails the delivery stays pending and is reclaimed later.
 func (w *Worker) handle(ctx context.Context, m redis.XMessage) error {
-	defer w.rc.XAck(ctx, w.cfg.Stream, w.cfg.Group, m.ID).Err()
+	defer w.track(m.ID)()
 
-	raw, ok := m.Values["payload"].(string)
-	if !ok {
-		// add sentry error handling
-		return nil
-	}
-	var job ConvertJob
-	if err := json.Unmarshal([]byte(raw), &job); err != nil {
+	raw, env, err := decodeMessage(m)
+	if err != nil {
 		// add sentry error handling
-		return nil
+		w.log.ErrorContext(ctx, "dead-letter undecodable message", "job_id", m.ID, "error", err)
+		if err := w.deadLetterUndecodable(ctx, rawMessage(m), m.ID, err); err != nil {
+			return fmt.Errorf("dead-letter %s: %w", m.ID, err)
+		}
+		return w.ack(ctx, m)
 	}
 	attempt := toInt(m.Values["attempt"])
+	ctx = logging.With(ctx, "job_id", m.ID, "job_type", env.Type, "attempt", attempt)
+	if key := objectKey(env); key != "" {
+		ctx = logging.With(ctx, "key", key)
+	}
+	firstAttemptAt := int64(toInt(m.Values["first_attempt_at"]))
+	if firstAttemptAt == 0 {
+		firstAttemptAt = time.Now().UnixMilli()
+	}
+
+	h, ok := w.handlers[env.Type]
+	if !ok {
+		err := fmt.Errorf("%w: no handler for job type %q", ErrPermanent, env.Type)
+		if dlqErr := w.deadLetter(ctx, m, raw, env, attempt, firstAttemptAt, err); dlqErr != nil {
+			return dlqErr
+		}
+		return w.ack(ctx, m)
+	}
+
+	if env.IdempotencyKey != "" {
+		done, err := w.rc.Exists(ctx, w.idempotencyKey(env)).Result()
+		if err == nil && done > 0 {
+			return w.ack(ctx, m)
+		}
+	}
+
+	start := time.Now()
+	jobErr := w.run(ctx, h, env, m.ID, attempt)
+	metrics.ObserveJob(env.Type, time.Since(start))
 
-	if err := w.process(ctx, job); err != nil {
-		if attempt+1 >= w.cfg.MaxAttempts {
+	if jobErr != nil {
+		giveUp := errors.Is(jobErr, ErrPermanent) || attempt+1 >= w.cfg.MaxAttempts
+		metrics.JobFailed(env.Type, giveUp)
+
+		if giveUp {
 			// add sentry error handling
-			return nil
+			w.log.ErrorContext(ctx, "job failed for good", "attempts", attempt+1, "error", jobErr)
+			if err := w.deadLetter(ctx, m, raw, env, attempt, firstAttemptAt, jobErr); err != nil {
+				return fmt.Errorf("dead-letter %s: %w", m.ID, err)
+			}
+			if fh, ok := h.(FailureHandler); ok {
+				fh.Failed(ctx, env, jobErr)
+			}
+			return w.ack(ctx, m)
 		}
-		// simple exponential backoff requeue
-		backoff := w.cfg.BackoffBase << attempt
-		time.AfterFunc(backoff, func() {
-			_ = w.rc.XAdd(context.Background(), &redis.XAddArgs{
-				Stream: w.cfg.Stream,
-				MaxLen: w.cfg.MaxLen,
-				Values: map[string]any{
-					"payload": raw,
-					"attempt": attempt + 1,
-				},
-			}).Err()
-		})
-		return err
+
+		w.log.WarnContext(ctx, "job failed, scheduling retry", "error", jobErr)
+		if err := w.scheduleRetry(ctx, m, raw, attempt, firstAttemptAt, jobErr); err != nil {
+			return fmt.Errorf("schedule retry of %s: %w", m.ID, err)
+		}
+		if err := w.ack(ctx, m); err != nil {
+			return err
+		}
+		return jobErr
 	}
-	return nil
+
+	if env.IdempotencyKey != "" {
+		_ = w.rc.Set(ctx, w.idempotencyKey(env), 1, idempotencyTTL).Err()
+	}
+	return w.ack(ctx, m)
 }
 
-func (w *Worker) process(ctx context.Context, job ConvertJob) error {
-	orig, _, err := w.storage.Download(ctx, job.ObjectKey)
-	if err != nil {
-		return fmt.Errorf("download %s: %w", job.ObjectKey, err)
+// run calls the handler within a consumer span linked to the span that enqueued the job
+func (w *Worker) run(ctx context.Context, h Handler, env Envelope, id string, attempt int) (err error) {
+	opts := []trace.SpanStartOption{
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithNewRoot(),
+		trace.WithAttributes(
+			attribute.String("messaging.destination.name", w.cfg.Stream),
+			attribute.String("messaging.message.id", id),
+			attribute.Int("mediahub.job.attempt", attempt),
+		),
+	}
+	// jobs enqueued before tracing existed carry no context
+	remote := trace.SpanContextFromContext(otel.GetTextMapPropagator().Extract(ctx, propagation.MapCarrier(env.TraceContext)))
+	if remote.IsValid() {
+		opts = append(opts, trace.WithLinks(trace.Link{SpanContext: remote}))
 	}
 
-	ext := strings.ToLower(job.Ext)
-	webpBytes, err := w.conv.ToWebP(bytes.NewReader(orig), ext)
-	if err != nil {
-		return fmt.Errorf("convert to webp: %w", err)
+	ctx, span := tracer.Start(ctx, "queue.Handle "+env.Type, opts...)
+	defer func() { tracing.End(span, err) }()
+
+	return h.Handle(ctx, env)
+}
+
+func (w *Worker) ack(ctx context.Context, m redis.XMessage) error {
+	return w.rc.XAck(ctx, w.cfg.Stream, w.cfg.Group, m.ID).Err()
+}
+
+func (w *Worker) idempotencyKey(env Envelope) string {
+	return w.cfg.Stream + ":done:" + env.IdempotencyKey
+}
+
+// decodeMessage returns the raw envelope of a stream message and its decoded form.
+// Messages written before envelopes existed carry a bare ConvertJob in "payload".
+func decodeMessage(m redis.XMessage) (string, Envelope, error) {
+	var env Envelope
+
+	if raw, ok := m.Values["job"].(string); ok {
+		if err := json.Unmarshal([]byte(raw), &env); err != nil {
+			return "", env, fmt.Errorf("decode envelope: %w", err)
+		}
+		return raw, env, nil
 	}
 
-	target := job.WebPKey
-	if target == "" {
-		target = job.ObjectKey + ".webp"
+	legacy, ok := m.Values["payload"].(string)
+	if !ok {
+		return "", env, errors.New("message has neither job nor payload")
+	}
+	env = Envelope{Type: JobTypeConvert, Version: 1, Payload: json.RawMessage(legacy)}
+	raw, err := json.Marshal(env)
+	if err != nil {
+		return "", env, err
 	}
+	return string(raw), env, nil
+}
 
-	if err := w.storage.UploadWithHook(ctx, target, "image/webp", webpBytes, nil); err != nil {
-		return fmt.Errorf("upload webp: %w", err)
+// rawMessage returns the job of a message that failed to decode, or all of its fields if it has none
+func rawMessage(m redis.XMessage) string {
+	if raw, ok := m.Values["job"].(string); ok {
+		return raw
 	}
-	return nil
+	raw, _ := json.Marshal(m.Values)
+	return string(raw)
 }
 
 func toInt(v any) int {
@@ -3,174 +3,216 @@ package r2
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"net/http"
+	"net/url"
 	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/trunov/mediahub/internal/cache"
 	conf "github.com/trunov/mediahub/internal/config"
+	"github.com/trunov/mediahub/internal/metrics"
+	"github.com/trunov/mediahub/internal/objectstore"
+	"github.com/trunov/mediahub/internal/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-var ErrQueueFull = errors.New("upload queue is full")
+var tracer = otel.Tracer("github.com/trunov/mediahub/internal/r2")
 
-type uploadReq struct {
-	ctx      context.Context
-	key      string
-	fileType string
-	payload  []byte
+const (
+	defaultRetryBaseDelay = 300 * time.Millisecond
+)
 
-	onSuccess func()
-}
+var _ objectstore.ObjectStore = (*S3)(nil)
 
 type S3 struct {
 	AccountID          string
 	Bucket             string
 	Region             string // usually "auto" for R2
+	Endpoint           string // overrides the R2 endpoint derived from AccountID
 	AwsAccessKeyId     string
 	AwsSecretAccessKey string
+	UsePathStyle       bool
+	InsecureSkipVerify bool
 
-	Workers        int
-	QueueSize      int
+	// retry policy of uploads, change it with SetRetries once the client runs
+	retryMu        sync.RWMutex
 	MaxRetries     int
 	RetryBaseDelay time.Duration
 
-	queue chan uploadReq
-	wg    sync.WaitGroup
-
 	S3Client *s3.Client
 	Uploader *manager.Uploader
 
 	Cache *cache.Cache
+
+	log *slog.Logger
 }
 
-func NewStorage(cfg *conf.R2Config, redisCache *cache.Cache) *S3 {
+func NewStorage(cfg *conf.R2Config, redisCache *cache.Cache, logger *slog.Logger) (*S3, error) {
+	region := cfg.Region
+	if region == "" {
+		region = "auto"
+	}
+
 	r2c := &S3{
 		AccountID:          cfg.AccountID,
 		Bucket:             cfg.BucketName,
-		Region:             "auto",
+		Region:             region,
+		Endpoint:           cfg.Endpoint,
 		AwsAccessKeyId:     cfg.AccessKeyID,
 		AwsSecretAccessKey: cfg.SecretKey,
-		Workers:            8,
-		QueueSize:          1000,
-		MaxRetries:         3,
-		RetryBaseDelay:     300 * time.Millisecond,
+		UsePathStyle:       !cfg.VirtualHostedStyle,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
 		Cache:              redisCache,
+		log:                logger.With("component", "r2"),
 	}
+	r2c.SetRetries(cfg.Retries(), cfg.RetryBaseDelay.Duration())
 	if err := r2c.Run(); err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
-	return r2c
+	return r2c, nil
 }
 func (s *S3) Run() error {
-	cfg, err := config.LoadDefaultConfig(context.TODO(),
+	opts := []func(*config.LoadOptions) error{
 		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(
 			s.AwsAccessKeyId, s.AwsSecretAccessKey, "",
 		)),
 		config.WithRegion(s.Region),
-	)
+	}
+	if s.InsecureSkipVerify {
+		opts = append(opts, config.WithHTTPClient(awshttp.NewBuildableClient().WithTransportOptions(func(t *http.Transport) {
+			if t.TLSClientConfig == nil {
+				t.TLSClientConfig = &tls.Config{}
+			}
+			t.TLSClientConfig.InsecureSkipVerify = true
+		})))
+	}
+
+	cfg, err := config.LoadDefaultConfig(context.TODO(), opts...)
 	if err != nil {
 		return fmt.Errorf("failed to load AWS config: %w", err)
 	}
 
+	endpoint := s.endpoint()
 	s.S3Client = s3.NewFromConfig(cfg, func(o *s3.Options) {
-		o.BaseEndpoint = aws.String(fmt.Sprintf("https://%s.r2.cloudflarestorage.com", s.AccountID))
-		o.UsePathStyle = true
+		if endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+		}
+		o.UsePathStyle = s.UsePathStyle
 	})
 	s.Uploader = manager.NewUploader(s.S3Client)
 
-	s.queue = make(chan uploadReq, s.QueueSize)
-	for i := 0; i < s.Workers; i++ {
-		s.wg.Add(1)
-		go s.worker()
-	}
-
-	log.Println("✅ R2 client + worker pool initialized.")
+	s.log.Info("client started", "bucket", s.Bucket)
 	return nil
 }
 
-// Close waits for all queued tasks to be processed.
-func (s *S3) Close() {
-	close(s.queue)
-	s.wg.Wait()
+// endpoint returns the configured endpoint, the R2 endpoint of the account,
+// or an empty string to let the SDK resolve the AWS S3 endpoint for the region
+func (s *S3) endpoint() string {
+	switch {
+	case s.Endpoint != "":
+		return s.Endpoint
+	case s.AccountID != "":
+		return fmt.Sprintf("https://%s.r2.cloudflarestorage.com", s.AccountID)
+	default:
+		return ""
+	}
 }
 
-// Upload tries to put an upload on the queue without blocking.
-// If the queue is full, it returns ErrQueueFull immediately.
-func (s *S3) UploadWithHook(ctx context.Context, key string, fileType string, payload []byte, onSuccess func()) error {
-	req := uploadReq{ctx: ctx, key: key, fileType: fileType, payload: payload, onSuccess: onSuccess}
-	select {
-	case s.queue <- req:
-		return nil
-	case <-ctx.Done():
-		return ctx.Err()
-	default:
-		return ErrQueueFull
+// SetRetries changes the retry policy of the following upload attempts.
+// A maxRetries of 0 disables retries, a zero baseDelay selects 300ms.
+func (s *S3) SetRetries(maxRetries int, baseDelay time.Duration) {
+	if maxRetries < 0 {
+		maxRetries = 0
+	}
+	if baseDelay <= 0 {
+		baseDelay = defaultRetryBaseDelay
 	}
+
+	s.retryMu.Lock()
+	defer s.retryMu.Unlock()
+	s.MaxRetries = maxRetries
+	s.RetryBaseDelay = baseDelay
 }
 
-func (s *S3) worker() {
-	defer s.wg.Done()
-	for req := range s.queue {
-		var err error
-		attempt := 0
-
-		for {
-			attempt++
-			_, err = s.Uploader.Upload(req.ctx, &s3.PutObjectInput{
-				Bucket:      aws.String(s.Bucket),
-				Key:         aws.String(req.key),
-				Body:        bytes.NewReader(req.payload),
-				ContentType: aws.String(req.fileType),
-			})
-			if err == nil {
-				if req.onSuccess != nil {
-					req.onSuccess() // cheap enough so synchronous
-				}
-				break
-			}
+func (s *S3) retries() (int, time.Duration) {
+	s.retryMu.RLock()
+	defer s.retryMu.RUnlock()
+	return s.MaxRetries, s.RetryBaseDelay
+}
 
-			// retry?
-			if attempt > s.MaxRetries {
-				break
-			}
+// Put stores the object synchronously, retrying up to MaxRetries times with exponential backoff,
+// and returns the error of the last attempt if all of them failed.
+func (s *S3) Put(ctx context.Context, key string, fileType string, payload []byte) (err error) {
+	ctx, span := tracer.Start(ctx, "r2.PutObject", trace.WithAttributes(
+		attribute.String("r2.key", key),
+		attribute.Int("r2.size", len(payload)),
+	))
+	defer func() { endSpan(span, err) }()
 
-			// backoff with jitter
-			backoff := s.backoffDelay(attempt)
-			timer := time.NewTimer(backoff)
-			select {
-			case <-timer.C:
-			case <-req.ctx.Done():
-				timer.Stop()
-			}
-			if req.ctx != nil && req.ctx.Err() != nil {
-				break
-			}
+	attempt := 0
+	for {
+		attempt++
+		_, err := s.Uploader.Upload(ctx, &s3.PutObjectInput{
+			Bucket:      aws.String(s.Bucket),
+			Key:         aws.String(key),
+			Body:        bytes.NewReader(payload),
+			ContentType: aws.String(fileType),
+		})
+		if err == nil {
+			return nil
+		}
+
+		// retry?
+		maxRetries, baseDelay := s.retries()
+		if attempt > maxRetries {
+			metrics.R2UploadFailures.Inc()
+			return fmt.Errorf("upload %q failed after %d attempts: %w", key, attempt, err)
 		}
 
+		// backoff with jitter
+		metrics.R2UploadRetries.Inc()
+		span.AddEvent("retry", trace.WithAttributes(attribute.Int("r2.attempt", attempt), attribute.String("error", err.Error())))
+		backoff := backoffDelay(baseDelay, attempt)
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("upload %q aborted: %w", key, ctx.Err())
+		}
 	}
 }
 
-func (s *S3) backoffDelay(attempt int) time.Duration {
-	delay := s.RetryBaseDelay << (attempt - 1)
+func backoffDelay(base time.Duration, attempt int) time.Duration {
+	delay := base << (attempt - 1)
 	jitter := time.Duration(int64(delay) / 10)
 	return delay - (jitter / 2) + time.Duration(int64(jitter)*time.Now().UnixNano()%2)
 }
 
-func (s *S3) Download(ctx context.Context, key string) ([]byte, string, error) {
+func (s *S3) Get(ctx context.Context, key string) (_ []byte, _ string, err error) {
+	ctx, span := tracer.Start(ctx, "r2.GetObject", trace.WithAttributes(attribute.String("r2.key", key)))
+	defer func() { endSpan(span, err) }()
+
 	out, err := s.S3Client.GetObject(ctx, &s3.GetObjectInput{
 		Bucket: aws.String(s.Bucket),
 		Key:    aws.String(key),
 	})
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to download %q: %w", key, err)
+		return nil, "", fmt.Errorf("failed to download %q: %w", key, mapNotFound(err))
 	}
 	defer out.Body.Close()
 
@@ -179,5 +221,106 @@ func (s *S3) Download(ctx context.Context, key string) ([]byte, string, error) {
 		return nil, "", fmt.Errorf("failed to read body for %q: %w", key, err)
 	}
 
-	return buf.Bytes(), *out.ContentType, nil
+	return buf.Bytes(), aws.ToString(out.ContentType), nil
+}
+
+func (s *S3) Head(ctx context.Context, key string) (_ objectstore.Object, err error) {
+	ctx, span := tracer.Start(ctx, "r2.HeadObject", trace.WithAttributes(attribute.String("r2.key", key)))
+	defer func() { endSpan(span, err) }()
+
+	out, err := s.S3Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return objectstore.Object{}, fmt.Errorf("failed to head %q: %w", key, mapNotFound(err))
+	}
+
+	return objectstore.Object{
+		Key:          key,
+		Size:         aws.ToInt64(out.ContentLength),
+		ContentType:  aws.ToString(out.ContentType),
+		LastModified: aws.ToTime(out.LastModified),
+	}, nil
+}
+
+func (s *S3) List(ctx context.Context, prefix string) (_ []objectstore.Object, err error) {
+	ctx, span := tracer.Start(ctx, "r2.ListObjects", trace.WithAttributes(attribute.String("r2.prefix", prefix)))
+	defer func() { endSpan(span, err) }()
+
+	var objects []objectstore.Object
+
+	p := s3.NewListObjectsV2Paginator(s.S3Client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.Bucket),
+		Prefix: aws.String(prefix),
+	})
+	for p.HasMorePages() {
+		page, err := p.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %q: %w", prefix, err)
+		}
+		for _, obj := range page.Contents {
+			objects = append(objects, objectstore.Object{
+				Key:          aws.ToString(obj.Key),
+				Size:         aws.ToInt64(obj.Size),
+				LastModified: aws.ToTime(obj.LastModified),
+			})
+		}
+	}
+
+	return objects, nil
+}
+
+func (s *S3) Copy(ctx context.Context, srcKey string, dstKey string) (err error) {
+	ctx, span := tracer.Start(ctx, "r2.CopyObject", trace.WithAttributes(
+		attribute.String("r2.key", dstKey),
+		attribute.String("r2.source_key", srcKey),
+	))
+	defer func() { endSpan(span, err) }()
+
+	_, err = s.S3Client.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:     aws.String(s.Bucket),
+		Key:        aws.String(dstKey),
+		CopySource: aws.String(url.PathEscape(s.Bucket + "/" + srcKey)),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to copy %q to %q: %w", srcKey, dstKey, mapNotFound(err))
+	}
+	return nil
+}
+
+// Delete removes the given objects. Missing objects are not treated as an error.
+func (s *S3) Delete(ctx context.Context, keys ...string) (err error) {
+	ctx, span := tracer.Start(ctx, "r2.DeleteObjects", trace.WithAttributes(attribute.Int("r2.count", len(keys))))
+	defer func() { endSpan(span, err) }()
+
+	for _, key := range keys {
+		_, err := s.S3Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+			Bucket: aws.String(s.Bucket),
+			Key:    aws.String(key),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to delete %q: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// endSpan ends the span of an S3 call, a missing object is an expected outcome rather than an error
+func endSpan(span trace.Span, err error) {
+	if errors.Is(err, objectstore.ErrNotFound) {
+		span.SetAttributes(attribute.Bool("r2.not_found", true))
+		err = nil
+	}
+	tracing.End(span, err)
+}
+
+// mapNotFound translates the S3 missing-object errors into objectstore.ErrNotFound
+func mapNotFound(err error) error {
+	var noSuchKey *types.NoSuchKey
+	var notFound *types.NotFound
+	if errors.As(err, &noSuchKey) || errors.As(err, &notFound) {
+		return objectstore.ErrNotFound
+	}
+	return err
 }
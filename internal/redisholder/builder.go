@@ -4,14 +4,16 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"time"
 
 	"github.com/redis/go-redis/v9"
 	"github.com/trunov/mediahub/internal/config"
+	"github.com/trunov/mediahub/internal/metrics"
 )
 
-func Build(ctx context.Context, cfg *config.Config) (*Holder, error) {
+func Build(ctx context.Context, cfg *config.Config, logger *slog.Logger) (*Holder, error) {
+	logger = logger.With("component", "redis")
 	var cl redis.UniversalClient
 	cl, err := newClusterClient(&cfg.Redis)
 	if err != nil {
@@ -20,18 +22,18 @@ func Build(ctx context.Context, cfg *config.Config) (*Holder, error) {
 		if err != nil {
 			return nil, fmt.Errorf("create redis client: %w", err)
 		}
-		log.Printf("redis: cluster client failed (%v); using single-node client", clusterErr)
+		logger.Info("cluster client failed, using single-node client", "error", clusterErr)
 	}
 
 	h := NewHolder(cl)
 
-	go healthLoop(ctx, h, cfg)
+	go healthLoop(ctx, h, cfg, logger)
 
 	return h, nil
 }
 
-func healthLoop(ctx context.Context, h *Holder, cfg *config.Config) {
-	log.Printf("redis: health loop started (interval=%v)", cfg.Redis.HealthCheckInterval*time.Second)
+func healthLoop(ctx context.Context, h *Holder, cfg *config.Config, logger *slog.Logger) {
+	logger.Info("health loop started", "interval", cfg.Redis.HealthCheckInterval)
 
 	ping := func() {
 		pingCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
@@ -39,10 +41,10 @@ func healthLoop(ctx context.Context, h *Holder, cfg *config.Config) {
 		cancel()
 
 		if err == nil {
-			log.Printf("redis: all good with redis")
+			logger.Debug("ping ok")
 			return
 		}
-		log.Printf("redis: ping failed (%v); attempting reconnect…", err)
+		logger.Warn("ping failed, reconnecting", "error", err)
 
 		var newCl redis.UniversalClient
 		var newErr error
@@ -52,27 +54,29 @@ func healthLoop(ctx context.Context, h *Holder, cfg *config.Config) {
 			newCl, newErr = newClient(&cfg.Redis)
 		}
 		if newErr != nil {
-			log.Printf("redis: reconnect failed: %v", newErr)
+			metrics.RedisReconnects.WithLabelValues("failed").Inc()
+			logger.Error("reconnect failed", "error", newErr)
 			return
 		}
+		metrics.RedisReconnects.WithLabelValues("ok").Inc()
 
 		old := h.swap(newCl)
 		if old != nil {
 			_ = old.Close()
 		}
-		log.Printf("redis: reconnected successfully")
+		logger.Info("reconnected")
 	}
 
 	ping()
 
-	t := time.NewTicker(cfg.Redis.HealthCheckInterval * time.Second)
+	t := time.NewTicker(cfg.Redis.HealthCheckInterval.Duration())
 	defer t.Stop()
 
 	for {
 		select {
 		case <-ctx.Done():
 			_ = h.Close()
-			log.Printf("redis: health loop stopped (%v)", ctx.Err())
+			logger.Info("health loop stopped", "reason", ctx.Err())
 			return
 		case <-t.C:
 			ping()
@@ -95,9 +99,9 @@ func newClusterClient(cfg *config.RedisConfig) (*redis.ClusterClient, error) {
 		RouteByLatency: true,
 		Password:       cfg.Password,
 		Addrs:          nodeAddrs,
-		DialTimeout:    cfg.DialTimeout * time.Second,
-		ReadTimeout:    cfg.ReadTimeout * time.Second,
-		WriteTimeout:   cfg.WriteTimeout * time.Second,
+		DialTimeout:    cfg.DialTimeout.Duration(),
+		ReadTimeout:    cfg.ReadTimeout.Duration(),
+		WriteTimeout:   cfg.WriteTimeout.Duration(),
 		PoolSize:       20,
 		PoolTimeout:    time.Duration(30) * time.Second,
 		MaxRetries:     30,
@@ -119,9 +123,9 @@ func newClient(cfg *config.RedisConfig) (*redis.Client, error) {
 			Addr:         node.Addr(),
 			Password:     cfg.Password,
 			DB:           cfg.DatabaseID,
-			DialTimeout:  cfg.DialTimeout * time.Second,
-			ReadTimeout:  cfg.ReadTimeout * time.Second,
-			WriteTimeout: cfg.WriteTimeout * time.Second,
+			DialTimeout:  cfg.DialTimeout.Duration(),
+			ReadTimeout:  cfg.ReadTimeout.Duration(),
+			WriteTimeout: cfg.WriteTimeout.Duration(),
 		})
 
 		err := cl.Ping(context.Background()).Err()
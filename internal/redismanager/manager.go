@@ -2,15 +2,20 @@ package redismanager
 
 import (
 	"context"
-	"crypto/sha1"
+	"crypto/rand"
 	"encoding/base64"
-	"math/rand"
+	"errors"
+	"fmt"
 	"strconv"
 	"time"
 
 	"github.com/redis/go-redis/v9"
 )
 
+const linkPrefix = "MH:Image:"
+
+var ErrTokenNotFound = errors.New("token not found or expired")
+
 type Manager struct {
 	client redis.UniversalClient
 	// probably conf for ttl
@@ -23,33 +28,76 @@ func NewManager(redisClient redis.UniversalClient) *Manager {
 	}
 }
 
-func (m *Manager) Create(ctx context.Context, imageKey string, ttl int) (string, error) {
-	hash := GenerateHash()
-	dur, err := time.ParseDuration(strconv.Itoa(ttl) + "s")
+// Create stores a temporary link token pointing at imageKey for ttl seconds.
+// A single-use token has to be removed with Consume once it has been used.
+func (m *Manager) Create(ctx context.Context, imageKey string, ttl int, singleUse bool) (string, error) {
+	hash, err := GenerateHash()
 	if err != nil {
-
 		return "", err
 	}
 
-	err = m.client.Set(ctx, "MH:Image:"+hash, imageKey, dur).Err()
+	dur, err := time.ParseDuration(strconv.Itoa(ttl) + "s")
 	if err != nil {
 		return "", err
 	}
 
+	pipe := m.client.TxPipeline()
+	pipe.HSet(ctx, linkPrefix+hash, "key", imageKey, "single_use", singleUse)
+	pipe.Expire(ctx, linkPrefix+hash, dur)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return "", err
+	}
+
 	return hash, nil
 }
 
-func GenerateHash() string {
-	src := rand.NewSource(time.Now().UnixNano() * 2)
-	r := rand.New(src)
+// Resolve returns the image key of a live token and whether it is single-use, without consuming it
+func (m *Manager) Resolve(ctx context.Context, token string) (string, bool, error) {
+	fields, err := m.client.HGetAll(ctx, linkPrefix+token).Result()
+	if err != nil {
+		return "", false, err
+	}
 
-	str := strconv.Itoa(int(time.Now().UnixNano()))
-	str += strconv.Itoa(r.Intn(65535))
+	imageKey, ok := fields["key"]
+	if !ok {
+		return "", false, ErrTokenNotFound
+	}
 
-	in := sha1.Sum([]byte(str))
+	singleUse, _ := strconv.ParseBool(fields["single_use"])
+	return imageKey, singleUse, nil
+}
 
-	out := make([]byte, base64.StdEncoding.EncodedLen(len(in)))
-	base64.StdEncoding.Encode(out, in[:])
+// Consume removes a single-use token after its object was fetched.
+// Concurrent uses are settled by DEL, only the one that deletes the token succeeds.
+func (m *Manager) Consume(ctx context.Context, token string) error {
+	deleted, err := m.client.Del(ctx, linkPrefix+token).Result()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return ErrTokenNotFound
+	}
+	return nil
+}
+
+// Revoke invalidates a token before it expires
+func (m *Manager) Revoke(ctx context.Context, token string) error {
+	deleted, err := m.client.Del(ctx, linkPrefix+token).Result()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return ErrTokenNotFound
+	}
+	return nil
+}
+
+// GenerateHash returns a random token that is safe to use in URLs
+func GenerateHash() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate token: %w", err)
+	}
 
-	return string(out)
+	return base64.RawURLEncoding.EncodeToString(b), nil
 }
@@ -2,14 +2,29 @@ package storage
 
 import (
 	"context"
+	"encoding/base64"
+	"errors"
 	"fmt"
-	"mime/multipart"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/trunov/mediahub/internal/entities"
 	"github.com/trunov/mediahub/internal/transport/handler"
 )
 
+// uniqueViolation is the SQLSTATE postgres returns when a UNIQUE constraint fails
+const uniqueViolation = "23505"
+
+// purgeLease is how long an image claimed by PurgeDeletedImages is left to its purger before another run retries it
+const purgeLease = 15 * time.Minute
+
+const imageColumns = `id, user_id, item_id, sku, context, description, width, height, project,
+	size, key, upload_status, webp_key, conversion_status, mime_type, is_deleted, order_index, created_timestamp, updated_timestamp, deleted_timestamp`
+
 type dbStorage struct {
 	dbpool *pgxpool.Pool
 }
@@ -32,6 +47,506 @@ func (s *dbStorage) Ping(ctx context.Context) error {
 	return nil
 }
 
-func (s *dbStorage) InsertImage(ctx context.Context, fh *multipart.FileHeader, imageParams handler.UploadImageParams) (entities.Image, error) {
-	return entities.Image{}, nil
+// InsertImage persists a new image row and returns it with the generated ID and timestamps.
+// A duplicate (project, user_id, key) is reported as entities.ErrImageAlreadyExists.
+func (s *dbStorage) InsertImage(ctx context.Context, img entities.Image) (entities.Image, error) {
+	tx, err := s.dbpool.Begin(ctx)
+	if err != nil {
+		return entities.Image{}, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	row := tx.QueryRow(ctx, `
+		INSERT INTO images (user_id, item_id, sku, context, description, width, height, project,
+			size, key, webp_key, mime_type, order_index)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+		RETURNING `+imageColumns,
+		img.UserID, img.ItemID, img.SKU, img.Context, img.Description, img.Width, img.Height, img.Project,
+		img.Size, img.Key, img.WebPKey, img.MimeType, img.OrderIndex,
+	)
+
+	inserted, err := scanImage(row)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+			return entities.Image{}, entities.ErrImageAlreadyExists
+		}
+		return entities.Image{}, fmt.Errorf("failed to insert image: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return entities.Image{}, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return inserted, nil
+}
+
+func scanImage(row pgx.Row) (entities.Image, error) {
+	var img entities.Image
+	err := row.Scan(
+		&img.ID,
+		&img.UserID,
+		&img.ItemID,
+		&img.SKU,
+		&img.Context,
+		&img.Description,
+		&img.Width,
+		&img.Height,
+		&img.Project,
+		&img.Size,
+		&img.Key,
+		&img.UploadStatus,
+		&img.WebPKey,
+		&img.ConversionStatus,
+		&img.MimeType,
+		&img.IsDeleted,
+		&img.OrderIndex,
+		&img.CreatedTimestamp,
+		&img.UpdatedTimestamp,
+		&img.DeletedTimestamp,
+	)
+	return img, err
+}
+
+// GetImage returns a live image; soft-deleted ones are reported as entities.ErrImageNotFound unless includeDeleted is set
+func (s *dbStorage) GetImage(ctx context.Context, id int64, includeDeleted bool) (entities.Image, error) {
+	row := s.dbpool.QueryRow(ctx, `SELECT `+imageColumns+` FROM images
+		WHERE id = $1 AND ($2 OR NOT COALESCE(is_deleted, FALSE))`, id, includeDeleted)
+
+	img, err := scanImage(row)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return entities.Image{}, entities.ErrImageNotFound
+		}
+		return entities.Image{}, fmt.Errorf("failed to get image %d: %w", id, err)
+	}
+
+	images := []entities.Image{img}
+	if err := s.attachVariants(ctx, images); err != nil {
+		return entities.Image{}, err
+	}
+
+	return images[0], nil
+}
+
+// HasLiveImage reports whether key is the original of an image that is not soft-deleted
+func (s *dbStorage) HasLiveImage(ctx context.Context, key string) (bool, error) {
+	var live bool
+	err := s.dbpool.QueryRow(ctx, `
+		SELECT EXISTS (SELECT 1 FROM images WHERE key = $1 AND NOT COALESCE(is_deleted, FALSE))`, key).Scan(&live)
+	if err != nil {
+		return false, fmt.Errorf("failed to look up image %q: %w", key, err)
+	}
+	return live, nil
+}
+
+// ListImages returns images of a project/user ordered by order_index.
+// The (project, user_id) prefix lets postgres use idx_images_lookup; pagination is keyset based on (order_index, id).
+func (s *dbStorage) ListImages(ctx context.Context, params handler.ListImagesParams) (entities.ImagePage, error) {
+	args := []any{params.Project, params.UserID, params.IsDeleted}
+	query := `SELECT ` + imageColumns + ` FROM images
+		WHERE project = $1 AND user_id = $2 AND COALESCE(is_deleted, FALSE) = $3`
+
+	if params.ItemID != 0 {
+		args = append(args, params.ItemID)
+		query += fmt.Sprintf(" AND item_id = $%d", len(args))
+	}
+	if params.Context != "" {
+		args = append(args, params.Context)
+		query += fmt.Sprintf(" AND context = $%d", len(args))
+	}
+	if params.Cursor != "" {
+		orderIndex, id, err := decodeCursor(params.Cursor)
+		if err != nil {
+			return entities.ImagePage{}, err
+		}
+		args = append(args, orderIndex, id)
+		query += fmt.Sprintf(" AND (order_index, id) > ($%d, $%d)", len(args)-1, len(args))
+	}
+
+	// fetch one extra row to know whether another page exists
+	args = append(args, params.Limit+1)
+	query += fmt.Sprintf(" ORDER BY order_index, id LIMIT $%d", len(args))
+
+	rows, err := s.dbpool.Query(ctx, query, args...)
+	if err != nil {
+		return entities.ImagePage{}, fmt.Errorf("failed to list images: %w", err)
+	}
+	defer rows.Close()
+
+	page := entities.ImagePage{Images: make([]entities.Image, 0, params.Limit)}
+	for rows.Next() {
+		img, err := scanImage(rows)
+		if err != nil {
+			return entities.ImagePage{}, fmt.Errorf("failed to scan image: %w", err)
+		}
+		page.Images = append(page.Images, img)
+	}
+	if err := rows.Err(); err != nil {
+		return entities.ImagePage{}, fmt.Errorf("failed to list images: %w", err)
+	}
+
+	if len(page.Images) > params.Limit {
+		page.Images = page.Images[:params.Limit]
+		last := page.Images[len(page.Images)-1]
+		page.NextCursor = encodeCursor(last.OrderIndex, last.ID)
+	}
+
+	if err := s.attachVariants(ctx, page.Images); err != nil {
+		return entities.ImagePage{}, err
+	}
+
+	return page, nil
+}
+
+// encodeCursor packs the keyset position of the last returned row into a URL safe token
+func encodeCursor(orderIndex int16, id int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d:%d", orderIndex, id)))
+}
+
+func decodeCursor(cursor string) (int16, int64, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, 0, entities.ErrInvalidCursor
+	}
+
+	orderPart, idPart, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return 0, 0, entities.ErrInvalidCursor
+	}
+
+	orderIndex, err := strconv.ParseInt(orderPart, 10, 16)
+	if err != nil {
+		return 0, 0, entities.ErrInvalidCursor
+	}
+	id, err := strconv.ParseInt(idPart, 10, 64)
+	if err != nil {
+		return 0, 0, entities.ErrInvalidCursor
+	}
+
+	return int16(orderIndex), id, nil
+}
+
+// SoftDeleteImage flags the image as deleted. Deleting an already deleted image keeps its original deleted_timestamp.
+func (s *dbStorage) SoftDeleteImage(ctx context.Context, id int64) (entities.Image, error) {
+	row := s.dbpool.QueryRow(ctx, `
+		UPDATE images
+		SET is_deleted = TRUE,
+			deleted_timestamp = COALESCE(deleted_timestamp, now()),
+			updated_timestamp = now()
+		WHERE id = $1
+		RETURNING `+imageColumns, id)
+
+	img, err := scanImage(row)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return entities.Image{}, entities.ErrImageNotFound
+		}
+		return entities.Image{}, fmt.Errorf("failed to delete image %d: %w", id, err)
+	}
+
+	return img, nil
+}
+
+// RestoreImage clears the deleted flag. Images already claimed by the purge may have lost objects
+// and are reported as entities.ErrImageNotFound.
+func (s *dbStorage) RestoreImage(ctx context.Context, id int64) (entities.Image, error) {
+	row := s.dbpool.QueryRow(ctx, `
+		UPDATE images
+		SET is_deleted = FALSE,
+			deleted_timestamp = NULL,
+			updated_timestamp = now()
+		WHERE id = $1 AND purge_started_timestamp IS NULL
+		RETURNING `+imageColumns, id)
+
+	img, err := scanImage(row)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return entities.Image{}, entities.ErrImageNotFound
+		}
+		return entities.Image{}, fmt.Errorf("failed to restore image %d: %w", id, err)
+	}
+
+	return img, nil
+}
+
+// PurgeDeletedImages claims up to limit images soft-deleted before the given time, calls purge for each of them
+// and removes the rows whose purge succeeded.
+// The claim is committed before any object is deleted, so no transaction stays open across the object storage calls,
+// and a claimed image can no longer be restored. Images whose purge failed stay claimed and are retried
+// by a later run once purgeLease has passed, which also covers a purger that died halfway.
+func (s *dbStorage) PurgeDeletedImages(ctx context.Context, before time.Time, limit int, purge func(img entities.Image) error) (int, error) {
+	rows, err := s.dbpool.Query(ctx, `
+		UPDATE images SET purge_started_timestamp = now()
+		WHERE id IN (
+			SELECT id FROM images
+			WHERE is_deleted AND deleted_timestamp < $1
+				AND (purge_started_timestamp IS NULL OR purge_started_timestamp < now() - make_interval(secs => $3))
+			ORDER BY deleted_timestamp
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING `+imageColumns, before, limit, purgeLease.Seconds())
+	if err != nil {
+		return 0, fmt.Errorf("failed to claim deleted images: %w", err)
+	}
+
+	images, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (entities.Image, error) {
+		return scanImage(row)
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to scan deleted images: %w", err)
+	}
+
+	purged := make([]int64, 0, len(images))
+	for _, img := range images {
+		if err := purge(img); err != nil {
+			continue
+		}
+		purged = append(purged, img.ID)
+	}
+	if len(purged) == 0 {
+		return 0, nil
+	}
+
+	if _, err := s.dbpool.Exec(ctx, `DELETE FROM images WHERE id = ANY($1)`, purged); err != nil {
+		return 0, fmt.Errorf("failed to delete purged images: %w", err)
+	}
+
+	return len(purged), nil
+}
+
+// UpdateImage applies a partial metadata update to a live (not soft-deleted) image
+func (s *dbStorage) UpdateImage(ctx context.Context, id int64, params handler.UpdateImageParams) (entities.Image, error) {
+	args := []any{id}
+	set := []string{"updated_timestamp = now()"}
+
+	if params.SKU != nil {
+		args = append(args, nullIfEmpty(*params.SKU))
+		set = append(set, fmt.Sprintf("sku = $%d", len(args)))
+	}
+	if params.Context != nil {
+		args = append(args, *params.Context)
+		set = append(set, fmt.Sprintf("context = $%d", len(args)))
+	}
+	if params.Description != nil {
+		args = append(args, nullIfEmpty(*params.Description))
+		set = append(set, fmt.Sprintf("description = $%d", len(args)))
+	}
+	if params.OrderIndex != nil {
+		args = append(args, int16(*params.OrderIndex))
+		set = append(set, fmt.Sprintf("order_index = $%d", len(args)))
+	}
+
+	row := s.dbpool.QueryRow(ctx, `
+		UPDATE images SET `+strings.Join(set, ", ")+`
+		WHERE id = $1 AND NOT COALESCE(is_deleted, FALSE)
+		RETURNING `+imageColumns, args...)
+
+	img, err := scanImage(row)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return entities.Image{}, entities.ErrImageNotFound
+		}
+		return entities.Image{}, fmt.Errorf("failed to update image %d: %w", id, err)
+	}
+
+	return img, nil
+}
+
+// ReorderImages rewrites order_index of all live images of an item in one transaction.
+// params.ImageIDs must contain exactly the item's images, otherwise entities.ErrOrderMismatch is returned.
+func (s *dbStorage) ReorderImages(ctx context.Context, params handler.ReorderImagesParams) ([]entities.Image, error) {
+	tx, err := s.dbpool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, `
+		SELECT id FROM images
+		WHERE project = $1 AND user_id = $2 AND item_id = $3 AND NOT COALESCE(is_deleted, FALSE)
+		FOR UPDATE`, params.Project, params.UserID, params.ItemID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock item images: %w", err)
+	}
+
+	current, err := pgx.CollectRows(rows, pgx.RowTo[int64])
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock item images: %w", err)
+	}
+	if len(current) == 0 {
+		return nil, entities.ErrImageNotFound
+	}
+	if !sameIDs(current, params.ImageIDs) {
+		return nil, entities.ErrOrderMismatch
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE images
+		SET order_index = v.position - 1,
+			updated_timestamp = now()
+		FROM unnest($1::bigint[]) WITH ORDINALITY AS v(id, position)
+		WHERE images.id = v.id`, params.ImageIDs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reorder images: %w", err)
+	}
+
+	rows, err = tx.Query(ctx, `
+		SELECT `+imageColumns+` FROM images
+		WHERE id = ANY($1)
+		ORDER BY order_index`, params.ImageIDs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read reordered images: %w", err)
+	}
+
+	images, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (entities.Image, error) {
+		return scanImage(row)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan reordered images: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return images, nil
+}
+
+// sameIDs reports whether both slices hold the same set of ids; want is known to be free of duplicates
+func sameIDs(have, want []int64) bool {
+	if len(have) != len(want) {
+		return false
+	}
+
+	set := make(map[int64]struct{}, len(have))
+	for _, id := range have {
+		set[id] = struct{}{}
+	}
+	for _, id := range want {
+		if _, ok := set[id]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func nullIfEmpty(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+func (s *dbStorage) SetUploadStatus(ctx context.Context, id int64, status string) (entities.Image, error) {
+	row := s.dbpool.QueryRow(ctx, `
+		UPDATE images
+		SET upload_status = $2,
+			updated_timestamp = now()
+		WHERE id = $1
+		RETURNING `+imageColumns, id, status)
+
+	img, err := scanImage(row)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return entities.Image{}, entities.ErrImageNotFound
+		}
+		return entities.Image{}, fmt.Errorf("failed to update upload status of image %d: %w", id, err)
+	}
+
+	return img, nil
+}
+
+// MarkConverted stores the WebP derivative key once it has been uploaded
+func (s *dbStorage) MarkConverted(ctx context.Context, project string, userID int64, key string, webpKey string) error {
+	return s.setConversion(ctx, project, userID, key, entities.ConversionDone, &webpKey)
+}
+
+func (s *dbStorage) MarkConversionFailed(ctx context.Context, project string, userID int64, key string) error {
+	return s.setConversion(ctx, project, userID, key, entities.ConversionFailed, nil)
+}
+
+func (s *dbStorage) setConversion(ctx context.Context, project string, userID int64, key string, status string, webpKey *string) error {
+	tag, err := s.dbpool.Exec(ctx, `
+		UPDATE images
+		SET conversion_status = $4,
+			webp_key = $5,
+			updated_timestamp = now()
+		WHERE project = $1 AND user_id = $2 AND key = $3`,
+		project, userID, key, status, webpKey,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update conversion of %q: %w", key, err)
+	}
+	if tag.RowsAffected() == 0 {
+		return entities.ErrImageNotFound
+	}
+	return nil
+}
+
+// SaveVariant records a generated variant of the image identified by project/user/key.
+// Regenerating a variant replaces the previous row.
+func (s *dbStorage) SaveVariant(ctx context.Context, project string, userID int64, key string, variant entities.ImageVariant) error {
+	tag, err := s.dbpool.Exec(ctx, `
+		INSERT INTO image_variants (image_id, name, key, width, height, size, mime_type)
+		SELECT id, $4, $5, $6, $7, $8, $9 FROM images
+		WHERE project = $1 AND user_id = $2 AND key = $3
+		ON CONFLICT (image_id, name) DO UPDATE
+		SET key = EXCLUDED.key,
+			width = EXCLUDED.width,
+			height = EXCLUDED.height,
+			size = EXCLUDED.size,
+			mime_type = EXCLUDED.mime_type,
+			created_timestamp = now()`,
+		project, userID, key, variant.Name, variant.Key, variant.Width, variant.Height, variant.Size, variant.MimeType,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save variant %q of %q: %w", variant.Name, key, err)
+	}
+	if tag.RowsAffected() == 0 {
+		return entities.ErrImageNotFound
+	}
+	return nil
+}
+
+// attachVariants loads the variants of all given images with a single query
+func (s *dbStorage) attachVariants(ctx context.Context, images []entities.Image) error {
+	if len(images) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, len(images))
+	byID := make(map[int64]*entities.Image, len(images))
+	for i := range images {
+		ids[i] = images[i].ID
+		byID[images[i].ID] = &images[i]
+	}
+
+	rows, err := s.dbpool.Query(ctx, `
+		SELECT image_id, name, key, width, height, size, mime_type
+		FROM image_variants
+		WHERE image_id = ANY($1)
+		ORDER BY image_id, name`, ids)
+	if err != nil {
+		return fmt.Errorf("failed to load variants: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var imageID int64
+		var v entities.ImageVariant
+		if err := rows.Scan(&imageID, &v.Name, &v.Key, &v.Width, &v.Height, &v.Size, &v.MimeType); err != nil {
+			return fmt.Errorf("failed to scan variant: %w", err)
+		}
+		if img, ok := byID[imageID]; ok {
+			img.Variants = append(img.Variants, v)
+		}
+	}
+	return rows.Err()
+}
+
+// Close waits for checked out connections to be released and closes the pool
+func (s *dbStorage) Close() {
+	s.dbpool.Close()
 }
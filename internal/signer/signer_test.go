@@ -0,0 +1,237 @@
+package signer
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/trunov/mediahub/internal/config"
+)
+
+func TestNew(t *testing.T) {
+	yes, no := true, false
+
+	tests := []struct {
+		name    string
+		cfg     config.SigningConfig
+		wantNil bool
+		wantErr bool
+	}{
+		{name: "no keys disables signing", cfg: config.SigningConfig{}, wantNil: true},
+		{name: "no keys but required", cfg: config.SigningConfig{Required: &yes}, wantErr: true},
+		{name: "no keys, explicitly optional", cfg: config.SigningConfig{Required: &no}, wantNil: true},
+		{name: "first key is active by default", cfg: config.SigningConfig{Keys: []config.SigningKey{{ID: "a", Secret: "s"}}}},
+		{
+			name: "active key",
+			cfg:  config.SigningConfig{Keys: []config.SigningKey{{ID: "a", Secret: "s"}, {ID: "b", Secret: "t"}}, ActiveKey: "b"},
+		},
+		{
+			name:    "unknown active key",
+			cfg:     config.SigningConfig{Keys: []config.SigningKey{{ID: "a", Secret: "s"}}, ActiveKey: "b"},
+			wantErr: true,
+		},
+		{name: "key without secret", cfg: config.SigningConfig{Keys: []config.SigningKey{{ID: "a"}}}, wantErr: true},
+		{name: "key without id", cfg: config.SigningConfig{Keys: []config.SigningKey{{Secret: "s"}}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := New(tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("New() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && (s == nil) != tt.wantNil {
+				t.Errorf("New() = %v, want nil %v", s, tt.wantNil)
+			}
+		})
+	}
+}
+
+func TestVerify(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	keys := []config.SigningKey{{ID: "old", Secret: "old-secret"}, {ID: "new", Secret: "new-secret"}}
+
+	mustNew := func(t *testing.T, cfg config.SigningConfig) *Signer {
+		t.Helper()
+		s, err := New(cfg)
+		if err != nil {
+			t.Fatalf("New: %v", err)
+		}
+		return s
+	}
+	signOld := func(t *testing.T, path string, query url.Values, expires time.Time) url.Values {
+		t.Helper()
+		signed, err := mustNew(t, config.SigningConfig{Keys: keys, ActiveKey: "old"}).Sign(path, query, expires)
+		if err != nil {
+			t.Fatalf("Sign: %v", err)
+		}
+		return signed
+	}
+
+	// verifies with "new" active, as after a rotation that kept the old key
+	verifier := mustNew(t, config.SigningConfig{Keys: keys, ActiveKey: "new"})
+	withoutOld := mustNew(t, config.SigningConfig{Keys: keys[1:]})
+
+	tests := []struct {
+		name     string
+		verifier *Signer
+		path     string
+		query    func(t *testing.T) url.Values
+		want     error
+	}{
+		{
+			name:     "valid",
+			verifier: verifier,
+			path:     "/img/p/1/a.png",
+			query: func(t *testing.T) url.Values {
+				return signOld(t, "/img/p/1/a.png", url.Values{"w": {"100"}}, now.Add(time.Hour))
+			},
+		},
+		{
+			name:     "valid until the second it expires",
+			verifier: verifier,
+			path:     "/img/p/1/a.png",
+			query: func(t *testing.T) url.Values {
+				return signOld(t, "/img/p/1/a.png", nil, now)
+			},
+		},
+		{
+			name:     "expired",
+			verifier: verifier,
+			path:     "/img/p/1/a.png",
+			query: func(t *testing.T) url.Values {
+				return signOld(t, "/img/p/1/a.png", nil, now.Add(-time.Second))
+			},
+			want: ErrExpired,
+		},
+		{
+			name:     "other path",
+			verifier: verifier,
+			path:     "/img/p/1/b.png",
+			query: func(t *testing.T) url.Values {
+				return signOld(t, "/img/p/1/a.png", nil, now.Add(time.Hour))
+			},
+			want: ErrInvalidSignature,
+		},
+		{
+			name:     "changed transform",
+			verifier: verifier,
+			path:     "/img/p/1/a.png",
+			query: func(t *testing.T) url.Values {
+				q := signOld(t, "/img/p/1/a.png", url.Values{"w": {"100"}}, now.Add(time.Hour))
+				q.Set("w", "4000")
+				return q
+			},
+			want: ErrInvalidSignature,
+		},
+		{
+			name:     "added parameter",
+			verifier: verifier,
+			path:     "/img/p/1/a.png",
+			query: func(t *testing.T) url.Values {
+				q := signOld(t, "/img/p/1/a.png", nil, now.Add(time.Hour))
+				q.Set("h", "4000")
+				return q
+			},
+			want: ErrInvalidSignature,
+		},
+		{
+			name:     "extended expiry",
+			verifier: verifier,
+			path:     "/img/p/1/a.png",
+			query: func(t *testing.T) url.Values {
+				q := signOld(t, "/img/p/1/a.png", nil, now.Add(-time.Hour))
+				q.Set(ParamExpires, "99999999999")
+				return q
+			},
+			want: ErrInvalidSignature,
+		},
+		{
+			name:     "key removed after rotation",
+			verifier: withoutOld,
+			path:     "/img/p/1/a.png",
+			query: func(t *testing.T) url.Values {
+				return signOld(t, "/img/p/1/a.png", nil, now.Add(time.Hour))
+			},
+			want: ErrInvalidSignature,
+		},
+		{
+			name:     "key id swapped",
+			verifier: verifier,
+			path:     "/img/p/1/a.png",
+			query: func(t *testing.T) url.Values {
+				q := signOld(t, "/img/p/1/a.png", nil, now.Add(time.Hour))
+				q.Set(ParamKeyID, "new")
+				return q
+			},
+			want: ErrInvalidSignature,
+		},
+		{
+			name:     "malformed signature",
+			verifier: verifier,
+			path:     "/img/p/1/a.png",
+			query: func(t *testing.T) url.Values {
+				q := signOld(t, "/img/p/1/a.png", nil, now.Add(time.Hour))
+				q.Set(ParamSignature, "%%%")
+				return q
+			},
+			want: ErrInvalidSignature,
+		},
+		{
+			name:     "unsigned",
+			verifier: verifier,
+			path:     "/img/p/1/a.png",
+			query:    func(t *testing.T) url.Values { return url.Values{"w": {"100"}} },
+			want:     ErrInvalidSignature,
+		},
+		{
+			name:     "signing disabled",
+			verifier: nil,
+			path:     "/img/p/1/a.png",
+			query: func(t *testing.T) url.Values {
+				return signOld(t, "/img/p/1/a.png", nil, now.Add(time.Hour))
+			},
+			want: ErrNotConfigured,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.verifier.Verify(tt.path, tt.query(t), now)
+			if !errors.Is(err, tt.want) || (tt.want == nil && err != nil) {
+				t.Errorf("Verify() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestSignAfterRotation(t *testing.T) {
+	keys := []config.SigningKey{{ID: "old", Secret: "old-secret"}, {ID: "new", Secret: "new-secret"}}
+	s, err := New(config.SigningConfig{Keys: keys, ActiveKey: "new"})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	query := url.Values{"w": {"100"}, ParamSignature: {"stale"}}
+	signed, err := s.Sign("/img/a.png", query, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	if got := signed.Get(ParamKeyID); got != "new" {
+		t.Errorf("kid = %q, want the active key new", got)
+	}
+	if query.Get(ParamSignature) != "stale" || query.Has(ParamKeyID) {
+		t.Errorf("Sign modified its input: %v", query)
+	}
+	if err := s.Verify("/img/a.png", signed, time.Now()); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+}
+
+func TestSignDisabled(t *testing.T) {
+	var s *Signer
+	if _, err := s.Sign("/img/a.png", nil, time.Now()); !errors.Is(err, ErrNotConfigured) {
+		t.Errorf("Sign() error = %v, want ErrNotConfigured", err)
+	}
+}
@@ -0,0 +1,132 @@
+package handler
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/trunov/mediahub/internal/config"
+	"github.com/trunov/mediahub/internal/queue"
+)
+
+type DeadLetterQueue interface {
+	List(ctx context.Context, after string, count int64) ([]queue.DeadLetter, error)
+	Get(ctx context.Context, id string) (queue.DeadLetter, error)
+	Replay(ctx context.Context, id string) error
+	ReplayAll(ctx context.Context) (int, error)
+	Purge(ctx context.Context, ids ...string) error
+	PurgeAll(ctx context.Context) (int64, error)
+}
+
+// AdminHandler serves operational endpoints, guarded by the admin bearer token
+type AdminHandler struct {
+	dlq DeadLetterQueue
+	cfg *config.Config
+}
+
+func NewAdmin(dlq DeadLetterQueue, cfg *config.Config) *AdminHandler {
+	return &AdminHandler{
+		dlq: dlq,
+		cfg: cfg,
+	}
+}
+
+// RequireToken rejects requests without "Authorization: Bearer <admin.token>".
+// Without a configured token the admin API is disabled.
+func (h *AdminHandler) RequireToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := h.cfg.Admin.Token
+		if token == "" {
+			writeJSONError(w, "admin api is disabled", http.StatusNotFound)
+			return
+		}
+
+		got := r.Header.Get("Authorization")
+		if subtle.ConstantTimeCompare([]byte(got), []byte("Bearer "+token)) != 1 {
+			writeJSONError(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (h *AdminHandler) ListDeadLetters(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	limit := parseInt64Default(q.Get("limit"), defaultPageLimit)
+	if limit < 1 || limit > 1000 {
+		writeJSONError(w, "limit must be between 1 and 1000", http.StatusBadRequest)
+		return
+	}
+
+	jobs, err := h.dlq.List(r.Context(), q.Get("after"), limit)
+	if err != nil {
+		writeDeadLetterError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{"jobs": jobs})
+}
+
+func (h *AdminHandler) GetDeadLetter(w http.ResponseWriter, r *http.Request) {
+	job, err := h.dlq.Get(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		writeDeadLetterError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, job)
+}
+
+func (h *AdminHandler) ReplayDeadLetter(w http.ResponseWriter, r *http.Request) {
+	if err := h.dlq.Replay(r.Context(), chi.URLParam(r, "id")); err != nil {
+		writeDeadLetterError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *AdminHandler) ReplayAllDeadLetters(w http.ResponseWriter, r *http.Request) {
+	n, err := h.dlq.ReplayAll(r.Context())
+	if err != nil {
+		writeJSONError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{"replayed": n})
+}
+
+func (h *AdminHandler) PurgeDeadLetter(w http.ResponseWriter, r *http.Request) {
+	if err := h.dlq.Purge(r.Context(), chi.URLParam(r, "id")); err != nil {
+		writeDeadLetterError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *AdminHandler) PurgeAllDeadLetters(w http.ResponseWriter, r *http.Request) {
+	n, err := h.dlq.PurgeAll(r.Context())
+	if err != nil {
+		writeJSONError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{"purged": n})
+}
+
+func writeDeadLetterError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, queue.ErrDeadLetterNotFound):
+		writeJSONError(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, queue.ErrInvalidDeadLetterID):
+		writeJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSONError(w, err.Error(), http.StatusInternalServerError)
+}
@@ -3,40 +3,94 @@ package handler
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
 	"mime/multipart"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/gabriel-vasile/mimetype"
+	"github.com/go-chi/chi/v5"
 	"github.com/go-playground/validator/v10"
 	"github.com/trunov/mediahub/internal/config"
 	"github.com/trunov/mediahub/internal/entities"
+	"github.com/trunov/mediahub/internal/metrics"
+	"github.com/trunov/mediahub/internal/signer"
+	"github.com/trunov/mediahub/internal/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+var tracer = otel.Tracer("github.com/trunov/mediahub/internal/transport/handler")
+
+// transformMaxAge is how long caches may keep an unsigned rendition
+const transformMaxAge = 24 * time.Hour
+
 type UseCase interface {
 	UploadImage(ctx context.Context, file multipart.File, fh *multipart.FileHeader, ext string, fileType string, imageParams UploadImageParams) (entities.Image, error)
+	GetImage(ctx context.Context, id int64, includeDeleted bool) (entities.Image, error)
+	ListImages(ctx context.Context, params ListImagesParams) (entities.ImagePage, error)
+	DeleteImage(ctx context.Context, id int64) error
+	RestoreImage(ctx context.Context, id int64) (entities.Image, error)
+	UpdateImage(ctx context.Context, id int64, params UpdateImageParams) (entities.Image, error)
+	ReorderImages(ctx context.Context, params ReorderImagesParams) ([]entities.Image, error)
+	TransformImage(ctx context.Context, params TransformParams) ([]byte, string, error)
+	CreateImageLink(ctx context.Context, id int64, params CreateLinkParams) (entities.ImageLink, error)
+	OpenLink(ctx context.Context, token string) ([]byte, string, error)
+	RevokeLink(ctx context.Context, token string) error
+	SignImageURL(ctx context.Context, id int64, params SignURLParams) (entities.SignedURL, error)
 }
 
 type Handler struct {
 	useCase   UseCase
 	cfg       *config.Config
+	upload    atomic.Pointer[config.UploadConfig] // replaced on config reload
 	validator *validator.Validate
+	signer    *signer.Signer
+	log       *slog.Logger
 }
 
-func New(useCase UseCase, cfg *config.Config) *Handler {
-	return &Handler{
+func New(useCase UseCase, cfg *config.Config, signer *signer.Signer, logger *slog.Logger) *Handler {
+	h := &Handler{
 		useCase:   useCase,
 		cfg:       cfg,
-		validator: validator.New(),
+		validator: newValidator(),
+		signer:    signer,
+		log:       logger.With("component", "http"),
 	}
+	h.SetUploadConfig(cfg.Upload)
+	return h
+}
+
+// SetUploadConfig changes the upload limits and allowed types of the following requests
+func (h *Handler) SetUploadConfig(cfg config.UploadConfig) {
+	h.upload.Store(&cfg)
 }
 
 func (h *Handler) UploadImage(w http.ResponseWriter, r *http.Request) {
-	r.Body = http.MaxBytesReader(w, r.Body, h.cfg.Upload.MaxRequestBodyMB<<20)
+	upload := h.upload.Load()
+	r.Body = http.MaxBytesReader(w, r.Body, upload.MaxRequestBodyMB<<20)
+
+	start := time.Now()
+	rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	w = rec
+	var project, fileType string
+	var size int64
+	defer func() {
+		metrics.ObserveUpload(project, fileType, rec.status, size, time.Since(start))
+	}()
 
-	maxMultipartMem := h.cfg.Upload.MaxMultipartMemoryMB
-	if err := r.ParseMultipartForm(maxMultipartMem << 20); err != nil {
+	maxMultipartMem := upload.MaxMultipartMemoryMB
+	_, span := tracer.Start(r.Context(), "handler.ParseMultipartForm")
+	err := r.ParseMultipartForm(maxMultipartMem << 20)
+	tracing.End(span, err)
+	if err != nil {
 		writeMultipartError(w, err)
 		return
 	}
@@ -51,6 +105,7 @@ func (h *Handler) UploadImage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	defer file.Close()
+	size = fh.Size
 
 	params := UploadImageParams{
 		// should add proper validation
@@ -69,6 +124,7 @@ func (h *Handler) UploadImage(w http.ResponseWriter, r *http.Request) {
 		_ = json.NewEncoder(w).Encode(validationErrorsToMap(err))
 		return
 	}
+	project = metricsProject(h.cfg.Variants, params.Project)
 
 	mime, err := mimetype.DetectReader(file)
 	if err != nil {
@@ -83,18 +139,28 @@ func (h *Handler) UploadImage(w http.ResponseWriter, r *http.Request) {
 	}
 
 	ext := mime.Extension()
-	fileType := mime.String()
+	fileType = mime.String()
+	trace.SpanFromContext(r.Context()).SetAttributes(
+		attribute.String("mediahub.project", params.Project),
+		attribute.String("image.mime_type", fileType),
+		attribute.Int64("image.size", size),
+	)
 
-	if err := validateMimeType(fileType); err != nil {
+	if !upload.AllowsMIMEType(fileType) {
 		writeJSONError(w, fmt.Sprintf("unsupported file type: %s", fileType), http.StatusBadRequest)
 		return
 	}
 
-	ctx := context.Background()
-
-	img, err := h.useCase.UploadImage(ctx, file, fh, ext, fileType, params)
+	img, err := h.useCase.UploadImage(r.Context(), file, fh, ext, fileType, params)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		switch {
+		case errors.Is(err, entities.ErrImageAlreadyExists):
+			writeJSONError(w, err.Error(), http.StatusConflict)
+		case errors.Is(err, entities.ErrUploadFailed):
+			writeJSONError(w, err.Error(), http.StatusBadGateway)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
 		return
 	}
 
@@ -106,3 +172,343 @@ func (h *Handler) UploadImage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 }
+
+// metricsProject keeps the project label of the upload metrics bounded:
+// only projects with their own variants are labelled, the rest count as "other"
+func metricsProject(variants config.VariantsConfig, project string) string {
+	if _, ok := variants[project]; ok && project != "*" {
+		return project
+	}
+	return "other"
+}
+
+func (h *Handler) GetImage(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseImageID(w, r)
+	if !ok {
+		return
+	}
+
+	// soft-deleted images are only returned with ?includeDeleted=1
+	img, err := h.useCase.GetImage(r.Context(), id, r.URL.Query().Get("includeDeleted") == "1")
+	if err != nil {
+		if errors.Is(err, entities.ErrImageNotFound) {
+			writeJSONError(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		writeJSONError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, img)
+}
+
+func (h *Handler) ListImages(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	params := ListImagesParams{
+		Project:   q.Get("project"),
+		UserID:    parseInt64Default(q.Get("userID"), 0),
+		ItemID:    parseInt64Default(q.Get("itemID"), 0),
+		Context:   q.Get("context"),
+		IsDeleted: q.Get("isDeleted") == "1",
+		Cursor:    q.Get("cursor"),
+		Limit:     int(parseInt64Default(q.Get("limit"), defaultPageLimit)),
+	}
+
+	if err := h.validator.Struct(params); err != nil {
+		writeJSON(w, http.StatusBadRequest, validationErrorsToMap(err))
+		return
+	}
+
+	page, err := h.useCase.ListImages(r.Context(), params)
+	if err != nil {
+		if errors.Is(err, entities.ErrInvalidCursor) {
+			writeJSONError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSONError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, page)
+}
+
+func (h *Handler) DeleteImage(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseImageID(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.useCase.DeleteImage(r.Context(), id); err != nil {
+		if errors.Is(err, entities.ErrImageNotFound) {
+			writeJSONError(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		writeJSONError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) RestoreImage(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseImageID(w, r)
+	if !ok {
+		return
+	}
+
+	img, err := h.useCase.RestoreImage(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, entities.ErrImageNotFound) {
+			writeJSONError(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		writeJSONError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, img)
+}
+
+func (h *Handler) UpdateImage(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseImageID(w, r)
+	if !ok {
+		return
+	}
+
+	var params UpdateImageParams
+	if !decodeJSONBody(w, r, &params) {
+		return
+	}
+
+	if err := h.validator.Struct(params); err != nil {
+		writeJSON(w, http.StatusBadRequest, validationErrorsToMap(err))
+		return
+	}
+	if params.IsEmpty() {
+		writeJSONError(w, "nothing to update", http.StatusBadRequest)
+		return
+	}
+
+	img, err := h.useCase.UpdateImage(r.Context(), id, params)
+	if err != nil {
+		if errors.Is(err, entities.ErrImageNotFound) {
+			writeJSONError(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		writeJSONError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, img)
+}
+
+func (h *Handler) ReorderImages(w http.ResponseWriter, r *http.Request) {
+	var params ReorderImagesParams
+	if !decodeJSONBody(w, r, &params) {
+		return
+	}
+	params.ItemID = parseInt64Default(chi.URLParam(r, "itemID"), 0)
+
+	if err := h.validator.Struct(params); err != nil {
+		writeJSON(w, http.StatusBadRequest, validationErrorsToMap(err))
+		return
+	}
+
+	images, err := h.useCase.ReorderImages(r.Context(), params)
+	if err != nil {
+		switch {
+		case errors.Is(err, entities.ErrImageNotFound):
+			writeJSONError(w, err.Error(), http.StatusNotFound)
+		case errors.Is(err, entities.ErrOrderMismatch):
+			writeJSONError(w, err.Error(), http.StatusConflict)
+		default:
+			writeJSONError(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	writeJSON(w, http.StatusOK, entities.ImagePage{Images: images})
+}
+
+func (h *Handler) TransformImage(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	params := TransformParams{
+		Key:     chi.URLParam(r, "*"),
+		Width:   int(parseInt64Default(q.Get("w"), 0)),
+		Height:  int(parseInt64Default(q.Get("h"), 0)),
+		Fit:     q.Get("fit"),
+		Format:  q.Get("format"),
+		Quality: int(parseInt64Default(q.Get("q"), 0)),
+	}
+	if params.Format == "jpg" {
+		params.Format = "jpeg"
+	}
+
+	if err := h.validator.Struct(params); err != nil {
+		writeJSON(w, http.StatusBadRequest, validationErrorsToMap(err))
+		return
+	}
+	if !h.checkTransformSize(w, params.Width, params.Height) {
+		return
+	}
+
+	data, contentType, err := h.useCase.TransformImage(r.Context(), params)
+	if err != nil {
+		if errors.Is(err, entities.ErrImageNotFound) {
+			writeJSONError(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		writeJSONError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeImage(w, data, contentType, transformCacheControl(q, time.Now()))
+}
+
+// transformCacheControl lets caches keep a rendition for a day, but a signed one no longer than its signature is valid,
+// so a CDN doesn't keep serving the URL after it expired. VerifySignature has already checked exp.
+func transformCacheControl(q url.Values, now time.Time) string {
+	maxAge := int64(transformMaxAge / time.Second)
+	if q.Has(signer.ParamSignature) {
+		exp, err := strconv.ParseInt(q.Get(signer.ParamExpires), 10, 64)
+		if err != nil {
+			return "no-store"
+		}
+		maxAge = min(maxAge, exp-now.Unix())
+	}
+	if maxAge <= 0 {
+		return "no-store"
+	}
+	return fmt.Sprintf("public, max-age=%d", maxAge)
+}
+
+func (h *Handler) CreateImageLink(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseImageID(w, r)
+	if !ok {
+		return
+	}
+
+	var params CreateLinkParams
+	if r.ContentLength != 0 && !decodeJSONBody(w, r, &params) {
+		return
+	}
+
+	if err := h.validator.Struct(params); err != nil {
+		writeJSON(w, http.StatusBadRequest, validationErrorsToMap(err))
+		return
+	}
+
+	link, err := h.useCase.CreateImageLink(r.Context(), id, params)
+	if err != nil {
+		if errors.Is(err, entities.ErrImageNotFound) {
+			writeJSONError(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		writeJSONError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, link)
+}
+
+func (h *Handler) OpenLink(w http.ResponseWriter, r *http.Request) {
+	data, contentType, err := h.useCase.OpenLink(r.Context(), chi.URLParam(r, "token"))
+	if err != nil {
+		if errors.Is(err, entities.ErrLinkNotFound) || errors.Is(err, entities.ErrImageNotFound) {
+			writeJSONError(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		writeJSONError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// the link may be single-use or revoked, shared caches must not keep it
+	writeImage(w, data, contentType, "private, no-store")
+}
+
+func (h *Handler) RevokeLink(w http.ResponseWriter, r *http.Request) {
+	if err := h.useCase.RevokeLink(r.Context(), chi.URLParam(r, "token")); err != nil {
+		if errors.Is(err, entities.ErrLinkNotFound) {
+			writeJSONError(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		writeJSONError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) SignImageURL(w http.ResponseWriter, r *http.Request) {
+	if h.signer == nil {
+		writeJSONError(w, signer.ErrNotConfigured.Error(), http.StatusNotImplemented)
+		return
+	}
+
+	id, ok := parseImageID(w, r)
+	if !ok {
+		return
+	}
+
+	var params SignURLParams
+	if r.ContentLength != 0 && !decodeJSONBody(w, r, &params) {
+		return
+	}
+	if params.Format == "jpg" {
+		params.Format = "jpeg"
+	}
+
+	if err := h.validator.Struct(params); err != nil {
+		writeJSON(w, http.StatusBadRequest, validationErrorsToMap(err))
+		return
+	}
+	if !h.checkTransformSize(w, params.Width, params.Height) {
+		return
+	}
+
+	signed, err := h.useCase.SignImageURL(r.Context(), id, params)
+	if err != nil {
+		if errors.Is(err, entities.ErrImageNotFound) {
+			writeJSONError(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		writeJSONError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, signed)
+}
+
+// VerifySignature rejects /img requests carrying an invalid or expired signature,
+// and unsigned ones when signing.required is set
+func (h *Handler) VerifySignature(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+
+		if h.signer == nil || !q.Has(signer.ParamSignature) {
+			if h.cfg.Signing.RequireSigned() {
+				writeJSONError(w, "missing url signature", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if err := h.signer.Verify(r.URL.Path, q, time.Now()); err != nil {
+			writeJSONError(w, err.Error(), http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (h *Handler) checkTransformSize(w http.ResponseWriter, width int, height int) bool {
+	if maxSize := h.cfg.Transform.MaxSize(); width > maxSize || height > maxSize {
+		writeJSONError(w, fmt.Sprintf("width and height must not exceed %d", maxSize), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
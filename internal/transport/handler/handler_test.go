@@ -0,0 +1,95 @@
+package handler
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/trunov/mediahub/internal/config"
+)
+
+func TestTransformCacheControl(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+
+	tests := []struct {
+		name  string
+		query url.Values
+		want  string
+	}{
+		{name: "unsigned", query: url.Values{"w": {"100"}}, want: "public, max-age=86400"},
+		{name: "signed, expiring later", query: url.Values{"sig": {"x"}, "exp": {"1700172800"}}, want: "public, max-age=86400"},
+		{name: "signed, expiring within the day", query: url.Values{"sig": {"x"}, "exp": {"1700000600"}}, want: "public, max-age=600"},
+		{name: "signed, expiring now", query: url.Values{"sig": {"x"}, "exp": {"1700000000"}}, want: "no-store"},
+		{name: "signed, malformed expiry", query: url.Values{"sig": {"x"}, "exp": {"soon"}}, want: "no-store"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := transformCacheControl(tt.query, now); got != tt.want {
+				t.Errorf("transformCacheControl() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMetricsProject(t *testing.T) {
+	variants := config.VariantsConfig{
+		"shop": {{Name: "thumb", Width: 150}},
+		"*":    {{Name: "small", Width: 300}},
+	}
+
+	tests := []struct {
+		project string
+		want    string
+	}{
+		{"shop", "shop"},
+		{"blog", "other"},
+		{"*", "other"},
+		{"", "other"},
+	}
+
+	for _, tt := range tests {
+		if got := metricsProject(variants, tt.project); got != tt.want {
+			t.Errorf("metricsProject(%q) = %q, want %q", tt.project, got, tt.want)
+		}
+	}
+}
+
+func TestValidateUploadProject(t *testing.T) {
+	v := newValidator()
+	for _, tt := range []struct {
+		project string
+		valid   bool
+	}{
+		{"shop", true},
+		{"shop-eu_2", true},
+		{"Shop", false},
+		{"sh op", false},
+		{"_derived", false},
+		{"", false},
+	} {
+		params := UploadImageParams{ItemID: 1, Context: "gallery", Project: tt.project, UserID: 1}
+		if err := v.Struct(params); (err == nil) != tt.valid {
+			t.Errorf("project %q: Struct() = %v, want valid %v", tt.project, err, tt.valid)
+		}
+	}
+}
+
+func TestValidateSignURLTTL(t *testing.T) {
+	v := newValidator()
+	for _, tt := range []struct {
+		ttl   int
+		valid bool
+	}{
+		{0, true},
+		{3600, true},
+		{int(config.MaxSignedURLTTL / time.Second), true},
+		{int(config.MaxSignedURLTTL/time.Second) + 1, false},
+		{1 << 62, false},
+		{-1, false},
+	} {
+		if err := v.Struct(SignURLParams{TTL: tt.ttl}); (err == nil) != tt.valid {
+			t.Errorf("ttl %d: Struct() = %v, want valid %v", tt.ttl, err, tt.valid)
+		}
+	}
+}
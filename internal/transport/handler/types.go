@@ -5,7 +5,7 @@ type UploadImageParams struct {
 	SKU         string `validate:"omitempty,max=64"`  // images.sku
 	Context     string `validate:"required,max=64"`   // images.context (NOT NULL)
 	Description string `validate:"omitempty,max=255"` // images.description
-	Project     string `validate:"required,max=64"`   // images.project (NOT NULL)
+	Project     string `validate:"required,project"`  // images.project (NOT NULL), the first object key segment
 	OrderIndex  int64  `validate:"gte=0,lte=32767"`   // images.order_index (NOT NULL)
 
 	// Options
@@ -14,3 +14,58 @@ type UploadImageParams struct {
 	// Auth
 	UserID int64 `validate:"required"`
 }
+
+type ListImagesParams struct {
+	Project   string `validate:"required,max=64"` // images.project
+	UserID    int64  `validate:"required"`        // images.user_id
+	ItemID    int64  `validate:"gte=0"`           // images.item_id, 0 means any item
+	Context   string `validate:"omitempty,max=64"`
+	IsDeleted bool   // from query ?isDeleted=1, lists soft-deleted images instead of live ones
+
+	// Pagination
+	Cursor string // opaque cursor returned as next_cursor by the previous page
+	Limit  int    `validate:"gte=1,lte=100"`
+}
+
+// UpdateImageParams is a partial update: nil fields are left untouched.
+// An empty SKU or description clears the column.
+type UpdateImageParams struct {
+	SKU         *string `json:"sku" validate:"omitnil,max=64"`
+	Context     *string `json:"context" validate:"omitnil,min=1,max=64"`
+	Description *string `json:"description" validate:"omitnil,max=255"`
+	OrderIndex  *int64  `json:"order_index" validate:"omitnil,gte=0,lte=32767"`
+}
+
+func (p UpdateImageParams) IsEmpty() bool {
+	return p.SKU == nil && p.Context == nil && p.Description == nil && p.OrderIndex == nil
+}
+
+type ReorderImagesParams struct {
+	Project  string  `json:"project" validate:"required,max=64"`
+	UserID   int64   `json:"user_id" validate:"required"`
+	ItemID   int64   `json:"-" validate:"required"`                          // from the {itemID} route parameter
+	ImageIDs []int64 `json:"image_ids" validate:"required,max=32767,unique"` // every image of the item in its new order
+}
+
+type TransformParams struct {
+	Key     string `validate:"required,max=255"`                   // object key of the original
+	Width   int    `validate:"gte=0"`                              // ?w=, 0 keeps the aspect ratio
+	Height  int    `validate:"gte=0"`                              // ?h=, 0 keeps the aspect ratio
+	Fit     string `validate:"omitempty,oneof=contain cover fill"` // ?fit=, defaults to contain
+	Format  string `validate:"omitempty,oneof=jpeg png webp"`      // ?format=, defaults to the original format
+	Quality int    `validate:"gte=0,lte=100"`                      // ?q=, 0 uses the configured default
+}
+
+type CreateLinkParams struct {
+	TTL       int  `json:"ttl" validate:"gte=0,lte=604800"` // seconds, 0 uses the default of one hour
+	SingleUse bool `json:"single_use"`
+}
+
+type SignURLParams struct {
+	Width   int    `json:"w" validate:"gte=0"`
+	Height  int    `json:"h" validate:"gte=0"`
+	Fit     string `json:"fit" validate:"omitempty,oneof=contain cover fill"`
+	Format  string `json:"format" validate:"omitempty,oneof=jpeg png webp"`
+	Quality int    `json:"q" validate:"gte=0,lte=100"`
+	TTL     int    `json:"ttl" validate:"gte=0,lte=604800"` // seconds, 0 uses signing.default_ttl, at most config.MaxSignedURLTTL
+}
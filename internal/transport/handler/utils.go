@@ -2,12 +2,21 @@ package handler
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
 	"strings"
 
+	"github.com/go-chi/chi/v5"
 	"github.com/go-playground/validator/v10"
+	"github.com/trunov/mediahub/internal/keygen"
+)
+
+const (
+	defaultPageLimit = 50
+	// maxJSONBodyBytes limits metadata requests, which never carry file data
+	maxJSONBodyBytes = 1 << 20
 )
 
 type APIError struct {
@@ -30,6 +39,33 @@ func writeMultipartError(w http.ResponseWriter, err error) {
 	}
 }
 
+// parseImageID reads the {id} route parameter and writes a 400 if it is not a positive integer
+func parseImageID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil || id <= 0 {
+		writeJSONError(w, "invalid image id", http.StatusBadRequest)
+		return 0, false
+	}
+	return id, true
+}
+
+// decodeJSONBody decodes a JSON request body into v and writes a 400 on malformed input or a 413 on an oversized one
+func decodeJSONBody(w http.ResponseWriter, r *http.Request, v any) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxJSONBodyBytes))
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(v); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeJSONError(w, fmt.Sprintf("request body exceeds %d bytes", tooLarge.Limit), http.StatusRequestEntityTooLarge)
+			return false
+		}
+		writeJSONError(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
 func parseInt64Default(s string, def int64) int64 {
 	if s == "" {
 		return def
@@ -41,6 +77,15 @@ func parseInt64Default(s string, def int64) int64 {
 	return v
 }
 
+// newValidator adds the "project" tag, a project that can be used as an object key segment
+func newValidator() *validator.Validate {
+	v := validator.New()
+	_ = v.RegisterValidation("project", func(fl validator.FieldLevel) bool {
+		return keygen.ValidProject(fl.Field().String())
+	})
+	return v
+}
+
 func validationErrorsToMap(err error) map[string]string {
 	errs := map[string]string{}
 	if verrs, ok := err.(validator.ValidationErrors); ok {
@@ -51,6 +96,14 @@ func validationErrorsToMap(err error) map[string]string {
 				errs[field] = "is required"
 			case "max":
 				errs[field] = "exceeds maximum length"
+			case "min":
+				errs[field] = "must not be empty"
+			case "unique":
+				errs[field] = "contains duplicates"
+			case "oneof":
+				errs[field] = "must be one of: " + e.Param()
+			case "project":
+				errs[field] = "must be 1-64 characters of a-z, 0-9, _ and -, starting with a letter or digit"
 			case "gte", "lte":
 				errs[field] = "out of allowed range"
 			default:
@@ -63,6 +116,22 @@ func validationErrorsToMap(err error) map[string]string {
 	return errs
 }
 
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeImage(w http.ResponseWriter, data []byte, contentType string, cacheControl string) {
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Header().Set("Cache-Control", cacheControl)
+	w.WriteHeader(http.StatusOK)
+
+	_, _ = w.Write(data)
+}
+
 func writeJSONError(w http.ResponseWriter, message string, code int) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(code)
@@ -72,15 +141,13 @@ func writeJSONError(w http.ResponseWriter, message string, code int) {
 	})
 }
 
-var allowedMIMEs = map[string]struct{}{
-	"image/png":  {},
-	"image/jpeg": {},
-	"image/webp": {},
+// statusRecorder remembers the response status for metrics
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
 }
 
-func validateMimeType(mimeType string) error {
-	if _, ok := allowedMIMEs[mimeType]; !ok {
-		return fmt.Errorf("requested file upload with invalid type: %s", mimeType)
-	}
-	return nil
+func (r *statusRecorder) WriteHeader(code int) {
+	r.status = code
+	r.ResponseWriter.WriteHeader(code)
 }
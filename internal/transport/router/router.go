@@ -1,16 +1,68 @@
 package router
 
 import (
+	"net/http"
+
 	"github.com/go-chi/chi/v5"
+	"github.com/trunov/mediahub/internal/metrics"
 	"github.com/trunov/mediahub/internal/transport/handler"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-func NewRouter(h *handler.Handler) chi.Router {
+func NewRouter(h *handler.Handler, admin *handler.AdminHandler) chi.Router {
 	r := chi.NewRouter()
+	r.Use(otelhttp.NewMiddleware("http",
+		otelhttp.WithFilter(func(r *http.Request) bool { return r.URL.Path != "/metrics" }),
+	))
+	r.Use(nameSpanByRoute)
+	r.Use(handler.RequestID)
+	r.Use(h.LogRequests)
 
 	r.Route("/api", func(r chi.Router) {
 		r.Post("/images", h.UploadImage)
+		r.Get("/images", h.ListImages)
+		r.Get("/images/{id}", h.GetImage)
+		r.Patch("/images/{id}", h.UpdateImage)
+		r.Delete("/images/{id}", h.DeleteImage)
+		r.Post("/images/{id}/restore", h.RestoreImage)
+		r.Post("/images/{id}/links", h.CreateImageLink)
+		r.Post("/images/{id}/signed-url", h.SignImageURL)
+		r.Delete("/links/{token}", h.RevokeLink)
+
+		r.Put("/items/{itemID}/order", h.ReorderImages)
+	})
+
+	r.Route("/admin", func(r chi.Router) {
+		r.Use(admin.RequireToken)
+
+		r.Get("/dlq", admin.ListDeadLetters)
+		r.Delete("/dlq", admin.PurgeAllDeadLetters)
+		r.Post("/dlq/replay", admin.ReplayAllDeadLetters)
+		r.Get("/dlq/{id}", admin.GetDeadLetter)
+		r.Delete("/dlq/{id}", admin.PurgeDeadLetter)
+		r.Post("/dlq/{id}/replay", admin.ReplayDeadLetter)
 	})
 
+	r.With(h.VerifySignature).Get("/img/*", h.TransformImage)
+	r.Get("/t/{token}", h.OpenLink)
+
+	r.Handle("/metrics", metrics.Handler())
+
 	return r
 }
+
+// nameSpanByRoute names the request span after the matched route, e.g. "GET /api/images/{id}",
+// which is only known once chi has routed the request
+func nameSpanByRoute(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r)
+
+		if pattern := chi.RouteContext(r.Context()).RoutePattern(); pattern != "" {
+			span := trace.SpanFromContext(r.Context())
+			span.SetName(r.Method + " " + pattern)
+			span.SetAttributes(attribute.String("http.route", pattern))
+		}
+	})
+}
@@ -0,0 +1,84 @@
+package use_case
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/trunov/mediahub/internal/entities"
+	"github.com/trunov/mediahub/internal/objectstore"
+	"github.com/trunov/mediahub/internal/redismanager"
+	"github.com/trunov/mediahub/internal/transport/handler"
+)
+
+const defaultLinkTTL = 3600
+
+// CreateImageLink mints a temporary link token for a live image
+func (c *useCase) CreateImageLink(ctx context.Context, id int64, params handler.CreateLinkParams) (entities.ImageLink, error) {
+	img, err := c.storage.GetImage(ctx, id, false)
+	if err != nil {
+		return entities.ImageLink{}, err
+	}
+
+	ttl := params.TTL
+	if ttl == 0 {
+		ttl = defaultLinkTTL
+	}
+
+	token, err := c.redismanager.Create(ctx, img.Key, ttl, params.SingleUse)
+	if err != nil {
+		return entities.ImageLink{}, err
+	}
+
+	return entities.ImageLink{
+		Token:     token,
+		URL:       "/t/" + token,
+		SingleUse: params.SingleUse,
+		ExpiresAt: time.Now().Add(time.Duration(ttl) * time.Second).UTC(),
+	}, nil
+}
+
+// OpenLink resolves a link token and returns the linked object.
+// Links outlive a soft delete of their image but stop serving it.
+// A single-use token is consumed only once the object is in hand, so a failed attempt doesn't use it up.
+func (c *useCase) OpenLink(ctx context.Context, token string) ([]byte, string, error) {
+	key, singleUse, err := c.redismanager.Resolve(ctx, token)
+	if err != nil {
+		if errors.Is(err, redismanager.ErrTokenNotFound) {
+			return nil, "", entities.ErrLinkNotFound
+		}
+		return nil, "", err
+	}
+
+	if err := c.requireLive(ctx, key); err != nil {
+		return nil, "", err
+	}
+
+	data, contentType, err := c.objects.Get(ctx, key)
+	if err != nil {
+		if errors.Is(err, objectstore.ErrNotFound) {
+			return nil, "", entities.ErrImageNotFound
+		}
+		return nil, "", err
+	}
+
+	if singleUse {
+		if err := c.redismanager.Consume(ctx, token); err != nil {
+			if errors.Is(err, redismanager.ErrTokenNotFound) {
+				// used by a concurrent request meanwhile
+				return nil, "", entities.ErrLinkNotFound
+			}
+			return nil, "", err
+		}
+	}
+
+	return data, contentType, nil
+}
+
+func (c *useCase) RevokeLink(ctx context.Context, token string) error {
+	err := c.redismanager.Revoke(ctx, token)
+	if errors.Is(err, redismanager.ErrTokenNotFound) {
+		return entities.ErrLinkNotFound
+	}
+	return err
+}
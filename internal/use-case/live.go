@@ -0,0 +1,48 @@
+package use_case
+
+import (
+	"context"
+	"time"
+
+	"github.com/trunov/mediahub/internal/entities"
+	"github.com/trunov/mediahub/internal/keygen"
+)
+
+// liveKeyTTL bounds how long a live image key is cached; DeleteImage drops the entry right away
+const liveKeyTTL = time.Minute
+
+// requireLive returns entities.ErrImageNotFound unless key is the original of an image that is not soft-deleted.
+// Positive answers are cached, so hot images don't cost a query per request.
+func (c *useCase) requireLive(ctx context.Context, key string) error {
+	if keygen.IsRendition(key) {
+		return entities.ErrImageNotFound
+	}
+
+	if cached, err := c.cache.Get(ctx, liveCacheKey(key)); err == nil && cached != "" {
+		return nil
+	}
+
+	live, err := c.storage.HasLiveImage(ctx, key)
+	if err != nil {
+		return err
+	}
+	if !live {
+		return entities.ErrImageNotFound
+	}
+
+	if err := c.cache.Store(ctx, liveCacheKey(key), liveKeyTTL, "1"); err != nil {
+		c.log.WarnContext(ctx, "cache live image", "key", key, "error", err)
+	}
+	return nil
+}
+
+// forgetLive drops the cached answer of requireLive once the image was deleted
+func (c *useCase) forgetLive(ctx context.Context, key string) {
+	if err := c.cache.Remove(ctx, liveCacheKey(key)); err != nil {
+		c.log.WarnContext(ctx, "uncache live image", "key", key, "error", err)
+	}
+}
+
+func liveCacheKey(key string) string {
+	return "live:" + key
+}
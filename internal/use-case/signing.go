@@ -0,0 +1,58 @@
+package use_case
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/trunov/mediahub/internal/entities"
+	"github.com/trunov/mediahub/internal/transport/handler"
+)
+
+const defaultSignedURLTTL = 24 * time.Hour
+
+// SignImageURL returns a signed /img URL for the image with the requested transform options
+func (c *useCase) SignImageURL(ctx context.Context, id int64, params handler.SignURLParams) (entities.SignedURL, error) {
+	img, err := c.storage.GetImage(ctx, id, false)
+	if err != nil {
+		return entities.SignedURL{}, err
+	}
+
+	ttl := time.Duration(params.TTL) * time.Second
+	if ttl == 0 {
+		ttl = c.cfg.Signing.DefaultTTL.Duration()
+	}
+	if ttl == 0 {
+		ttl = defaultSignedURLTTL
+	}
+	expires := time.Now().Add(ttl).UTC().Truncate(time.Second)
+
+	query := url.Values{}
+	setNonZero(query, "w", params.Width)
+	setNonZero(query, "h", params.Height)
+	setNonZero(query, "q", params.Quality)
+	if params.Fit != "" {
+		query.Set("fit", params.Fit)
+	}
+	if params.Format != "" {
+		query.Set("format", params.Format)
+	}
+
+	path := "/img/" + img.Key
+	signed, err := c.signer.Sign(path, query, expires)
+	if err != nil {
+		return entities.SignedURL{}, err
+	}
+
+	return entities.SignedURL{
+		URL:       path + "?" + signed.Encode(),
+		ExpiresAt: expires,
+	}, nil
+}
+
+func setNonZero(query url.Values, name string, v int) {
+	if v != 0 {
+		query.Set(name, strconv.Itoa(v))
+	}
+}
@@ -0,0 +1,119 @@
+package use_case
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"path"
+
+	"github.com/trunov/mediahub/internal/entities"
+	"github.com/trunov/mediahub/internal/keygen"
+	"github.com/trunov/mediahub/internal/objectstore"
+	"github.com/trunov/mediahub/internal/processor"
+	"github.com/trunov/mediahub/internal/tracing"
+	"github.com/trunov/mediahub/internal/transport/handler"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TransformImage returns the original image resized and re-encoded according to params.
+// Results are looked up in the redis cache, then in object storage, and only rendered on a miss.
+func (c *useCase) TransformImage(ctx context.Context, params handler.TransformParams) ([]byte, string, error) {
+	params = c.normalizeTransform(params)
+	ctx, span := tracer.Start(ctx, "use_case.TransformImage", trace.WithAttributes(
+		attribute.String("image.key", params.Key),
+		attribute.Int("image.width", params.Width),
+		attribute.Int("image.height", params.Height),
+		attribute.String("image.format", params.Format),
+	))
+
+	data, contentType, source, err := c.transformImage(ctx, params)
+	span.SetAttributes(attribute.String("mediahub.transform.source", source))
+	tracing.End(span, err)
+	return data, contentType, err
+}
+
+// transformImage also reports where the result came from: cache, storage or render
+func (c *useCase) transformImage(ctx context.Context, params handler.TransformParams) ([]byte, string, string, error) {
+	// derived objects of deleted images stay in storage until purge, so this goes before any lookup
+	if err := c.requireLive(ctx, params.Key); err != nil {
+		return nil, "", "", err
+	}
+
+	derivedKey := derivedObjectKey(params)
+	contentType := processor.ContentType(params.Format)
+
+	if cached, err := c.cache.Get(ctx, derivedKey); err == nil {
+		if s, ok := cached.(string); ok && s != "" {
+			return []byte(s), contentType, "cache", nil
+		}
+	}
+
+	if data, _, err := c.objects.Get(ctx, derivedKey); err == nil {
+		c.cacheDerived(ctx, derivedKey, data)
+		return data, contentType, "storage", nil
+	}
+
+	original, _, err := c.objects.Get(ctx, params.Key)
+	if err != nil {
+		if errors.Is(err, objectstore.ErrNotFound) {
+			return nil, "", "", entities.ErrImageNotFound
+		}
+		return nil, "", "", err
+	}
+
+	img, err := processor.LoadImage(ctx, bytes.NewReader(original), &processor.ImageResizer{
+		Width:  params.Width,
+		Height: params.Height,
+		Fit:    params.Fit,
+	})
+	if err != nil {
+		return nil, "", "", fmt.Errorf("failed to decode %q: %w", params.Key, err)
+	}
+
+	data, err := processor.Encode(ctx, img, params.Format, params.Quality)
+	if err != nil {
+		return nil, "", "", fmt.Errorf("failed to encode %q: %w", params.Key, err)
+	}
+
+	// the response doesn't depend on persisting the derivative, failures only cost a re-render
+	if err := c.objects.Put(ctx, derivedKey, contentType, data); err != nil {
+		c.log.WarnContext(ctx, "store derived image", "key", derivedKey, "error", err)
+	}
+	c.cacheDerived(ctx, derivedKey, data)
+
+	return data, contentType, "render", nil
+}
+
+// normalizeTransform fills in defaults so equivalent requests share one derived object
+func (c *useCase) normalizeTransform(params handler.TransformParams) handler.TransformParams {
+	if params.Fit == "" {
+		params.Fit = processor.FitContain
+	}
+	if params.Format == "" {
+		params.Format = processor.FormatFromContentType(mime.TypeByExtension(path.Ext(params.Key)))
+	}
+	switch {
+	case params.Format == processor.FormatPNG:
+		params.Quality = 0 // png is lossless, q must not fragment the cache
+	case params.Quality == 0:
+		params.Quality = c.cfg.Transform.Quality()
+	}
+	return params
+}
+
+func (c *useCase) cacheDerived(ctx context.Context, key string, data []byte) {
+	if c.cfg.Transform.CacheTTL <= 0 || len(data) > c.cfg.Transform.CacheMaxBytes {
+		return
+	}
+	if err := c.cache.Store(ctx, key, c.cfg.Transform.CacheTTL.Duration(), data); err != nil {
+		c.log.WarnContext(ctx, "cache derived image", "key", key, "error", err)
+	}
+}
+
+func derivedObjectKey(params handler.TransformParams) string {
+	return fmt.Sprintf("%sw%d_h%d_%s_q%d.%s",
+		keygen.DerivedPrefix(params.Key), params.Width, params.Height, params.Fit, params.Quality, params.Format)
+}
@@ -3,74 +3,242 @@ package use_case
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
+	"math"
 	"mime/multipart"
 	"strings"
+	"time"
 
+	"github.com/trunov/mediahub/internal/config"
 	"github.com/trunov/mediahub/internal/entities"
+	"github.com/trunov/mediahub/internal/keygen"
 	"github.com/trunov/mediahub/internal/processor"
 	"github.com/trunov/mediahub/internal/queue"
+	"github.com/trunov/mediahub/internal/signer"
+	"github.com/trunov/mediahub/internal/tracing"
 	"github.com/trunov/mediahub/internal/transport/handler"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// maxKeyAttempts bounds how many candidate object keys are tried before giving up with a conflict
+const maxKeyAttempts = 5
+
+var tracer = otel.Tracer("github.com/trunov/mediahub/internal/use-case")
+
 type Storage interface {
-	InsertImage(ctx context.Context, fh *multipart.FileHeader, imageParams handler.UploadImageParams) (entities.Image, error)
+	InsertImage(ctx context.Context, img entities.Image) (entities.Image, error)
+	GetImage(ctx context.Context, id int64, includeDeleted bool) (entities.Image, error)
+	HasLiveImage(ctx context.Context, key string) (bool, error)
+	ListImages(ctx context.Context, params handler.ListImagesParams) (entities.ImagePage, error)
+	SoftDeleteImage(ctx context.Context, id int64) (entities.Image, error)
+	RestoreImage(ctx context.Context, id int64) (entities.Image, error)
+	UpdateImage(ctx context.Context, id int64, params handler.UpdateImageParams) (entities.Image, error)
+	SetUploadStatus(ctx context.Context, id int64, status string) (entities.Image, error)
+	ReorderImages(ctx context.Context, params handler.ReorderImagesParams) ([]entities.Image, error)
 }
 
 type RedisStore interface {
-	Create(ctx context.Context, imageKey string, ttl int) (string, error)
+	Create(ctx context.Context, imageKey string, ttl int, singleUse bool) (string, error)
+	Resolve(ctx context.Context, token string) (string, bool, error)
+	Consume(ctx context.Context, token string) error
+	Revoke(ctx context.Context, token string) error
+}
+
+type ObjectStorage interface {
+	Put(ctx context.Context, key string, contentType string, payload []byte) error
+	Get(ctx context.Context, key string) ([]byte, string, error)
 }
 
-type R2Storage interface {
-	UploadWithHook(ctx context.Context, key string, ext string, payload []byte, onSuccess func()) error
+type Cache interface {
+	Get(ctx context.Context, key string) (interface{}, error)
+	Store(ctx context.Context, key string, ttl time.Duration, value interface{}) error
+	Remove(ctx context.Context, key string) error
 }
 
 type useCase struct {
 	storage      Storage
 	redismanager RedisStore
-	r2Storage    R2Storage
+	objects      ObjectStorage
+	cache        Cache
 	wqueue       *queue.Producer
+	signer       *signer.Signer
+	cfg          *config.Config
+	log          *slog.Logger
 }
 
-func New(storage Storage, rm RedisStore, r2Storage R2Storage, wqueue *queue.Producer) *useCase {
+func New(storage Storage, rm RedisStore, objects ObjectStorage, cache Cache, wqueue *queue.Producer, signer *signer.Signer, cfg *config.Config, logger *slog.Logger) *useCase {
 	return &useCase{
 		storage:      storage,
 		redismanager: rm,
-		r2Storage:    r2Storage,
+		objects:      objects,
+		cache:        cache,
 		wqueue:       wqueue,
+		signer:       signer,
+		cfg:          cfg,
+		log:          logger.With("component", "use_case"),
 	}
 }
 
 func (c *useCase) UploadImage(ctx context.Context, file multipart.File, fh *multipart.FileHeader, ext string, fileType string, imageParams handler.UploadImageParams) (entities.Image, error) {
+	ctx, span := tracer.Start(ctx, "use_case.UploadImage", trace.WithAttributes(
+		attribute.String("mediahub.project", imageParams.Project),
+		attribute.String("image.mime_type", fileType),
+		attribute.Int64("image.size", fh.Size),
+	))
+
+	img, err := c.uploadImage(ctx, file, fh, ext, fileType, imageParams)
+	if err == nil {
+		span.SetAttributes(attribute.Int64("image.id", img.ID), attribute.String("image.key", img.Key))
+	}
+	tracing.End(span, err)
+	return img, err
+}
+
+func (c *useCase) uploadImage(ctx context.Context, file multipart.File, fh *multipart.FileHeader, ext string, fileType string, imageParams handler.UploadImageParams) (entities.Image, error) {
 	img := entities.Image{}
 
-	originalData, width, height, err := processImage(file, ext)
+	originalData, width, height, err := processImage(ctx, file, ext)
 	if err != nil {
 		return img, fmt.Errorf("error processing image: %v", err)
 	}
 
-	fmt.Println(width)
-	fmt.Println(height)
+	// images.width and images.height are SMALLINT
+	if width > math.MaxInt16 || height > math.MaxInt16 {
+		return img, fmt.Errorf("image dimensions %dx%d exceed the maximum of %d", width, height, math.MaxInt16)
+	}
+
+	filename := ""
+	if imageParams.PreserveFilename {
+		filename = fh.Filename
+	}
+	keys, err := keygen.New(imageParams.Project, imageParams.UserID, imageParams.ItemID, filename, originalData, ext)
+	if err != nil {
+		return img, err
+	}
+
+	record := entities.Image{
+		UserID:      imageParams.UserID,
+		ItemID:      imageParams.ItemID,
+		SKU:         optionalString(imageParams.SKU),
+		Context:     imageParams.Context,
+		Description: optionalString(imageParams.Description),
+		Width:       int16(width),
+		Height:      int16(height),
+		Project:     imageParams.Project,
+		Size:        int32(len(originalData)),
+		MimeType:    fileType,
+		OrderIndex:  int16(imageParams.OrderIndex),
+	}
 
-	key := "pro_test"
+	// The UNIQUE (project, user_id, key) constraint is the source of truth:
+	// on conflict we move on to the next candidate key.
+	for attempt := 0; attempt < maxKeyAttempts; attempt++ {
+		record.Key, err = keys.Key(attempt)
+		if err != nil {
+			return img, err
+		}
 
-	err = c.r2Storage.UploadWithHook(ctx, key, fileType, originalData, func() {
-		c.wqueue.EnqueueConvert(ctx, queue.ConvertJob{
-			ObjectKey:   key,
-			ContentType: fileType,
-			Ext:         strings.ToLower(ext),
-			// WebPKey:   optional override; default is objectKey + ".webp"
-		})
-	})
+		img, err = c.storage.InsertImage(ctx, record)
+		if !errors.Is(err, entities.ErrImageAlreadyExists) {
+			break
+		}
+	}
+	if err != nil {
+		return img, err
+	}
+
+	// Upload synchronously so the caller learns about storage failures;
+	// the row keeps the outcome even if the client has gone away meanwhile.
+	statusCtx := context.WithoutCancel(ctx)
+
+	if err := c.objects.Put(ctx, img.Key, fileType, originalData); err != nil {
+		if _, serr := c.storage.SetUploadStatus(statusCtx, img.ID, entities.UploadFailed); serr != nil {
+			c.log.ErrorContext(ctx, "mark upload as failed", "image_id", img.ID, "key", img.Key, "error", serr)
+		}
+		return img, fmt.Errorf("%w: %v", entities.ErrUploadFailed, err)
+	}
+
+	img, err = c.storage.SetUploadStatus(statusCtx, img.ID, entities.UploadDone)
 	if err != nil {
 		return img, err
 	}
 
+	err = c.wqueue.EnqueueConvert(statusCtx, queue.ConvertJob{
+		ImageID:     img.ID,
+		Project:     img.Project,
+		UserID:      img.UserID,
+		ObjectKey:   img.Key,
+		ContentType: fileType,
+		Ext:         strings.ToLower(ext),
+		// WebPKey:   optional override; default is objectKey + ".webp"
+	})
+	if err != nil {
+		// the original is stored, a missing WebP only leaves the conversion pending
+		c.log.ErrorContext(ctx, "enqueue webp conversion", "key", img.Key, "error", err)
+	}
+
+	if presets := c.cfg.Variants.For(img.Project); len(presets) > 0 {
+		err = c.wqueue.EnqueueVariants(statusCtx, queue.VariantsJob{
+			ImageID:   img.ID,
+			Project:   img.Project,
+			UserID:    img.UserID,
+			ObjectKey: img.Key,
+			Variants:  presets,
+		})
+		if err != nil {
+			c.log.ErrorContext(ctx, "enqueue variants", "key", img.Key, "error", err)
+		}
+	}
+
 	return img, nil
 }
 
-func processImage(file multipart.File, ext string) ([]byte, int, int, error) {
+func (c *useCase) GetImage(ctx context.Context, id int64, includeDeleted bool) (entities.Image, error) {
+	return c.storage.GetImage(ctx, id, includeDeleted)
+}
+
+func (c *useCase) ListImages(ctx context.Context, params handler.ListImagesParams) (entities.ImagePage, error) {
+	return c.storage.ListImages(ctx, params)
+}
+
+func (c *useCase) DeleteImage(ctx context.Context, id int64) error {
+	img, err := c.storage.SoftDeleteImage(ctx, id)
+	if err != nil {
+		return err
+	}
+	c.forgetLive(ctx, img.Key)
+	return nil
+}
+
+func (c *useCase) RestoreImage(ctx context.Context, id int64) (entities.Image, error) {
+	return c.storage.RestoreImage(ctx, id)
+}
+
+func (c *useCase) UpdateImage(ctx context.Context, id int64, params handler.UpdateImageParams) (entities.Image, error) {
+	return c.storage.UpdateImage(ctx, id, params)
+}
+
+func (c *useCase) ReorderImages(ctx context.Context, params handler.ReorderImagesParams) ([]entities.Image, error) {
+	return c.storage.ReorderImages(ctx, params)
+}
+
+// optionalString maps an empty form value to NULL
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+func processImage(ctx context.Context, file multipart.File, ext string) (_ []byte, _ int, _ int, err error) {
+	_, span := tracer.Start(ctx, "use_case.processImage", trace.WithAttributes(attribute.String("image.ext", ext)))
+	defer func() { tracing.End(span, err) }()
+
 	imgp := &processor.ImageProcessor{}
 	b, err := io.ReadAll(file)
 	if err != nil {